@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
@@ -21,12 +25,18 @@ import (
 	"phpservermanager/internal/config"
 	"phpservermanager/internal/handler"
 	"phpservermanager/internal/middleware"
+	"phpservermanager/internal/oidc"
+	"phpservermanager/internal/ratelimit"
+	"phpservermanager/internal/server"
+	"phpservermanager/internal/tlsconfig"
 )
 
 //go:embed web/static
 var staticFS embed.FS
 
 func main() {
+	server.RunLimitWrapper()
+
 	configDir := getConfigDir()
 	configPath := filepath.Join(configDir, "config.yaml")
 
@@ -55,20 +65,64 @@ func main() {
 	// Initialize the App
 	application := app.NewApp(cfg)
 	application.Startup(context.Background())
-	defer application.Shutdown(context.Background())
 
 	// Initialize the handlers
 	h := handler.NewHandler(application)
+	h.SecureCookies = cfg.Session.SecureCookie
+	h.Lockout = ratelimit.NewLockout(cfg.RateLimit.MaxFailuresPerIP, cfg.RateLimit.MaxFailuresPerUser, time.Duration(cfg.RateLimit.LockoutSeconds)*time.Second)
+	if cfg.OIDC.Enabled {
+		if h.OIDC, err = oidc.NewClient(cfg.OIDC); err != nil {
+			log.Fatalf("Failed to configure single sign-on: %v", err)
+		}
+	}
+
+	// Client IP restrictions
+	trustedProxies, err := middleware.ParseCIDRs(cfg.Access.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid access.trusted_proxies: %v", err)
+	}
+	uiAllowed, err := middleware.ParseCIDRs(cfg.Access.UIAllowed)
+	if err != nil {
+		log.Fatalf("Invalid access.ui_allowed_cidrs: %v", err)
+	}
+	apiAllowed, err := middleware.ParseCIDRs(cfg.Access.APIAllowed)
+	if err != nil {
+		log.Fatalf("Invalid access.api_allowed_cidrs: %v", err)
+	}
+	metricsAllowed, err := middleware.ParseCIDRs(cfg.Access.MetricsAllowed)
+	if err != nil {
+		log.Fatalf("Invalid access.metrics_allowed_cidrs: %v", err)
+	}
 
 	// Create router
 	r := mux.NewRouter()
 
 	// Create a new auth middleware
-	authMiddleware := middleware.Auth(cfg.Auth)
+	authMiddleware := middleware.Auth(application, h.Lockout)
+
+	// Rate limit API requests per client, before any password is checked
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit.RequestsPerSecond >= 0 {
+		limiter = ratelimit.NewLimiter(cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+	}
+	rateLimit := middleware.RateLimit(limiter)
 
 	// API endpoints
+	// Login and logout come before the API subrouter, outside of its auth
+	public := r.PathPrefix("/api").Subrouter()
+	public.Use(middleware.AllowCIDRs(apiAllowed))
+	public.Use(h.Requests.Middleware)
+	public.Use(rateLimit)
+	public.HandleFunc("/login", h.HandleLogin).Methods("POST")
+	public.HandleFunc("/logout", h.HandleLogout).Methods("POST")
+	public.HandleFunc("/login", h.HandleLoginOptions).Methods("GET")
+	public.HandleFunc("/oidc/login", h.HandleOIDCLogin).Methods("GET")
+	public.HandleFunc("/oidc/callback", h.HandleOIDCCallback).Methods("GET")
+
 	api := r.PathPrefix("/api").Subrouter()
-	api.Use(CORSMiddleware)
+	api.Use(middleware.AllowCIDRs(apiAllowed))
+	api.Use(h.Requests.Middleware)
+	api.Use(rateLimit)
 	api.Use(authMiddleware)
 	api.HandleFunc("/servers", h.HandleGetServers).Methods("GET")
 	api.HandleFunc("/servers", h.HandleCreateServer).Methods("POST")
@@ -77,24 +131,112 @@ func main() {
 	api.HandleFunc("/servers/{id}/start", h.HandleStartServer).Methods("POST")
 	api.HandleFunc("/servers/{id}/stop", h.HandleStopServer).Methods("POST")
 	api.HandleFunc("/servers/{id}/status", h.HandleServerStatus).Methods("GET")
+	api.HandleFunc("/servers/{id}/logs", h.HandleServerLogs).Methods("GET")
+	api.HandleFunc("/servers/{id}/logs/stream", h.HandleStreamServerLogs).Methods("GET")
+	api.HandleFunc("/servers/{id}/acme", h.HandleGetACME).Methods("GET")
+	api.HandleFunc("/servers/{id}/acme", h.HandleUpdateACME).Methods("PUT")
+	api.HandleFunc("/servers/{id}/acme/renew", h.HandleRenewACME).Methods("POST")
+	api.HandleFunc("/servers/{id}/acme/revoke", h.HandleRevokeACME).Methods("POST")
+	api.HandleFunc("/events", h.HandleEvents).Methods("GET")
 	api.HandleFunc("/settings", h.HandleGetServerSettings).Methods("GET")
 	api.HandleFunc("/settings", h.HandleUpdateServerSettings).Methods("PUT")
+	api.HandleFunc("/auth", h.HandleGetAuth).Methods("GET")
 	api.HandleFunc("/auth", h.HandleUpdateAuth).Methods("PUT")
-	// api.HandleFunc("/acme/status", h.HandleGetACMEStatus).Methods("GET")
-	// api.HandleFunc("/acme/settings", h.HandleUpdateACMESettings).Methods("PUT")
-	// api.HandleFunc("/acme/renew", h.HandleRenewACME).Methods("POST")
+	api.HandleFunc("/users", h.HandleGetUsers).Methods("GET")
+	api.HandleFunc("/users", h.HandleCreateUser).Methods("POST")
+	api.HandleFunc("/users/{username}", h.HandleGetUser).Methods("GET")
+	api.HandleFunc("/users/{username}", h.HandleUpdateUser).Methods("PUT")
+	api.HandleFunc("/users/{username}", h.HandleDeleteUser).Methods("DELETE")
+	api.HandleFunc("/users/{username}/totp", h.HandleResetUserTOTP).Methods("DELETE")
+	api.HandleFunc("/auth/totp/enroll", h.HandleEnrollTOTP).Methods("POST")
+	api.HandleFunc("/auth/totp/verify", h.HandleVerifyTOTP).Methods("POST")
+	api.HandleFunc("/auth/totp", h.HandleDisableTOTP).Methods("DELETE")
+	api.HandleFunc("/tokens", h.HandleGetTokens).Methods("GET")
+	api.HandleFunc("/tokens", h.HandleCreateToken).Methods("POST")
+	api.HandleFunc("/tokens/{id}", h.HandleDeleteToken).Methods("DELETE")
+	api.HandleFunc("/audit", h.HandleGetAudit).Methods("GET")
+
+	// Prometheus metrics, with their own optional credentials
+	if cfg.Metrics.Enabled {
+		if cfg.Metrics.BearerToken == "" && cfg.Metrics.PasswordHash == "" {
+			log.Printf("Warning: /metrics is enabled without credentials; set metrics.bearer_token or metrics.username and metrics.password_hash")
+		}
+		metricsHandler := middleware.MetricsAuth(cfg.Metrics, h.Lockout)(http.HandlerFunc(h.HandleMetrics))
+		r.Handle("/metrics", middleware.AllowCIDRs(metricsAllowed)(metricsHandler)).Methods("GET")
+	}
 
 	// Static files
 	staticContent, err := fs.Sub(staticFS, "web/static")
 	if err != nil {
 		log.Fatal(err)
 	}
-	r.PathPrefix("/").Handler(http.FileServer(http.FS(staticContent)))
+	r.PathPrefix("/").Handler(middleware.AllowCIDRs(uiAllowed)(http.FileServer(http.FS(staticContent))))
 
-	// Start web server
+	// Start web server. Request contexts are cancelled on shutdown so that
+	// long-lived streams don't hold it up.
 	bindAddr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
-	fmt.Printf("PHP Server Manager is running at http://%s\n", bindAddr)
-	log.Fatal(http.ListenAndServe(bindAddr, r))
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	tlsConfig, acmeChallenge, err := tlsconfig.Build(baseCtx, cfg.Server.TLS, cfg.Server.Host, configDir)
+	if err != nil {
+		log.Fatalf("Failed to set up TLS: %v", err)
+	}
+
+	var handler http.Handler = r
+	if tlsConfig != nil && cfg.Server.TLS.HSTSMaxAge > 0 {
+		handler = middleware.HSTS(cfg.Server.TLS.HSTSMaxAge)(handler)
+	}
+	srv := &http.Server{
+		Addr:        bindAddr,
+		Handler:     middleware.ClientIP(trustedProxies)(middleware.CORS(cfg.CORSAllowedOrigins)(handler)),
+		TLSConfig:   tlsConfig,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(cancelBase)
+
+	go func() {
+		var err error
+		if tlsConfig == nil {
+			fmt.Printf("PHP Server Manager is running at http://%s\n", bindAddr)
+			err = srv.ListenAndServe()
+		} else {
+			fmt.Printf("PHP Server Manager is running at https://%s\n", bindAddr)
+			err = srv.ListenAndServeTLS("", "")
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Redirect plain HTTP to HTTPS, answering ACME challenges on the way
+	var redirectSrv *http.Server
+	if tlsConfig != nil && cfg.Server.TLS.RedirectHTTPPort != "" {
+		redirectSrv = &http.Server{
+			Addr:    net.JoinHostPort(cfg.Server.Host, cfg.Server.TLS.RedirectHTTPPort),
+			Handler: acmeChallenge(tlsconfig.RedirectHandler(cfg.Server.Port)),
+		}
+		go func() {
+			if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// Wait for a termination signal, then stop the managed servers without
+	// forgetting which of them should be running on the next start
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	fmt.Println("Shutting down PHP Server Manager...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("Error shutting down web server: %v\n", err)
+	}
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(shutdownCtx)
+	}
+	application.Shutdown(shutdownCtx)
 }
 
 func getConfigDir() string {
@@ -169,7 +311,7 @@ func setupConfig(path string) error {
 			Port: port,
 		},
 		Auth: config.Auth{
-			Username: username,
+			Username:     username,
 			PasswordHash: string(hashedPassword),
 		},
 		ServersConfigPath: serversConfigPath,
@@ -186,19 +328,3 @@ func setupConfig(path string) error {
 
 	return nil
 }
-
-// CORSMiddleware adds CORS headers to the response
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
\ No newline at end of file
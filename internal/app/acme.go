@@ -0,0 +1,322 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+
+	"phpservermanager/internal/server"
+)
+
+var (
+	// ErrACMEDisabled is returned for certificate operations on servers
+	// without ACME
+	ErrACMEDisabled = errors.New("ACME is not enabled for this server")
+	// ErrUnknownDomain is returned for certificate operations on domains
+	// that aren't among the server's ACME domains
+	ErrUnknownDomain = errors.New("domain is not managed for this server")
+	// ErrDomainNotLocal is returned for ACME domains that don't resolve to
+	// this host, which would make their challenges fail
+	ErrDomainNotLocal = errors.New("domain does not resolve to this host")
+)
+
+// domainPattern matches the DNS names certificates can be obtained for
+// with HTTP or TLS-ALPN challenges; wildcards would need DNS challenges
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// domainLookupTimeout bounds the DNS lookups of the domain check
+const domainLookupTimeout = 5 * time.Second
+
+// ACMESettings are the ACME settings of a server
+type ACMESettings struct {
+	Enabled     bool     `json:"enabled"`
+	Email       string   `json:"email"`
+	Domains     []string `json:"domains"`
+	StoragePath string   `json:"storage_path"`
+}
+
+// ACMEStatus describes the ACME settings of a server, whether its
+// certificates are being managed and the certificates issued so far
+type ACMEStatus struct {
+	ACMESettings
+	Managed      bool          `json:"managed"`
+	Certificates []Certificate `json:"certificates"`
+}
+
+// acmeManager keeps the certificates of a running server renewed
+type acmeManager struct {
+	cache  *certmagic.Cache
+	cancel context.CancelFunc
+}
+
+// ValidDomain reports whether certificates can be obtained for name
+func ValidDomain(name string) bool {
+	return len(name) <= 253 && domainPattern.MatchString(name)
+}
+
+// CheckDomains makes sure every domain resolves to an address of this
+// host, either one of its interfaces or one of the public_ips in
+// config.yaml for hosts behind NAT
+func (a *App) CheckDomains(ctx context.Context, domains []string) error {
+	local := make(map[string]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			local[ipnet.IP.String()] = true
+		}
+	}
+	for _, ip := range a.publicIPs {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			local[parsed.String()] = true
+		}
+	}
+
+	for _, domain := range domains {
+		lookupCtx, cancel := context.WithTimeout(ctx, domainLookupTimeout)
+		ips, err := net.DefaultResolver.LookupIPAddr(lookupCtx, domain)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrDomainNotLocal, domain, err)
+		}
+		found := false
+		var resolved []string
+		for _, ip := range ips {
+			resolved = append(resolved, ip.IP.String())
+			if local[ip.IP.String()] {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %s resolves to %s", ErrDomainNotLocal, domain, strings.Join(resolved, ", "))
+		}
+	}
+	return nil
+}
+
+// GetACME returns the ACME settings and certificates of a server
+func (a *App) GetACME(ctx context.Context, id string) (ACMEStatus, error) {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists {
+		a.mu.Unlock()
+		return ACMEStatus{}, ErrServerNotFound
+	}
+	snapshot := s.Clone()
+	_, managed := a.acmeManagers[id]
+	a.mu.Unlock()
+
+	certs := loadCertificates(ctx, snapshot)
+	if certs == nil {
+		certs = []Certificate{}
+	}
+	return ACMEStatus{
+		ACMESettings: acmeSettings(snapshot),
+		Managed:      managed,
+		Certificates: certs,
+	}, nil
+}
+
+// UpdateACME changes the ACME settings of a server. Certificate management
+// of a running server restarts with the new settings.
+func (a *App) UpdateACME(ctx context.Context, id string, settings ACMESettings) error {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists {
+		a.mu.Unlock()
+		return ErrServerNotFound
+	}
+
+	before := s.Clone()
+	s.ACMEEnabled = settings.Enabled
+	s.ACMECertEmail = settings.Email
+	s.ACMEDomains = settings.Domains
+	s.ACMEStoragePath = settings.StoragePath
+	a.defaultACMEStoragePath(s)
+	a.audit(ctx, AuditACMEUpdate, id, s.Name, auditServerDiff(before, s), nil)
+	running := s.Running
+	a.stopACME(id)
+	a.mu.Unlock()
+
+	if running {
+		a.startACME(id)
+	}
+	go a.saveConfig()
+	return nil
+}
+
+// RenewCertificates renews the certificates of every ACME domain of a
+// server now, whether or not they are due
+func (a *App) RenewCertificates(ctx context.Context, id string) ([]Certificate, error) {
+	s, err := a.acmeServer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	magic, cache := a.newACMEConfig(s)
+	defer cache.Stop()
+
+	var errs []error
+	for _, domain := range s.ACMEDomains {
+		// Domains without a certificate yet get their first one
+		err := magic.RenewCertSync(ctx, domain, true)
+		if err != nil {
+			err = magic.ObtainCertSync(ctx, domain)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", domain, err))
+		}
+	}
+	err = errors.Join(errs...)
+	a.audit(ctx, AuditACMERenew, id, s.Name, nil, err)
+	if err != nil {
+		return nil, err
+	}
+	return loadCertificates(ctx, s), nil
+}
+
+// RevokeCertificate revokes the certificate of one of a server's ACME
+// domains with an RFC 5280 reason code and removes it from storage
+func (a *App) RevokeCertificate(ctx context.Context, id, domain string, reason int) error {
+	s, err := a.acmeServer(id)
+	if err != nil {
+		return err
+	}
+	known := false
+	for _, d := range s.ACMEDomains {
+		if d == domain {
+			known = true
+		}
+	}
+	if !known {
+		return ErrUnknownDomain
+	}
+
+	magic, cache := a.newACMEConfig(s)
+	defer cache.Stop()
+
+	err = magic.RevokeCert(ctx, domain, reason, false)
+	a.audit(ctx, AuditACMERevoke, id, domain, nil, err)
+	if err != nil {
+		return err
+	}
+
+	// A running server gets a new certificate in place of the revoked one
+	a.mu.Lock()
+	_, managed := a.acmeManagers[id]
+	a.stopACME(id)
+	a.mu.Unlock()
+	if managed {
+		a.startACME(id)
+	}
+	return nil
+}
+
+// acmeServer returns a copy of a server with ACME enabled
+func (a *App) acmeServer(id string) (*server.Server, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, exists := a.servers[id]
+	if !exists {
+		return nil, ErrServerNotFound
+	}
+	if !s.ACMEEnabled || len(s.ACMEDomains) == 0 {
+		return nil, ErrACMEDisabled
+	}
+	return s.Clone(), nil
+}
+
+// startACME starts managing the certificates of a server with ACME
+// enabled, obtaining missing ones and renewing them as they come due
+func (a *App) startACME(id string) {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists || !s.ACMEEnabled || len(s.ACMEDomains) == 0 {
+		a.mu.Unlock()
+		return
+	}
+	if _, running := a.acmeManagers[id]; running {
+		a.mu.Unlock()
+		return
+	}
+	snapshot := s.Clone()
+	ctx, cancel := context.WithCancel(a.ctx)
+	magic, cache := a.newACMEConfig(snapshot)
+	a.acmeManagers[id] = &acmeManager{cache: cache, cancel: cancel}
+	a.mu.Unlock()
+
+	// Obtaining certificates can take a while, so don't hold up the start
+	go func() {
+		if err := magic.ManageSync(ctx, snapshot.ACMEDomains); err != nil && ctx.Err() == nil {
+			a.logger(id).System("managing certificates for %s failed: %v", strings.Join(snapshot.ACMEDomains, ", "), err)
+		}
+	}()
+}
+
+// stopACME stops managing the certificates of a server. It must be called
+// with a.mu held.
+func (a *App) stopACME(id string) {
+	if m, exists := a.acmeManagers[id]; exists {
+		m.cancel()
+		m.cache.Stop()
+		delete(a.acmeManagers, id)
+	}
+}
+
+// newACMEConfig creates a certmagic configuration for the certificates of
+// s, with its own cache so that it can be stopped independently
+func (a *App) newACMEConfig(s *server.Server) (*certmagic.Config, *certmagic.Cache) {
+	var magic *certmagic.Config
+	cache := certmagic.NewCache(certmagic.CacheOptions{
+		GetConfigForCert: func(certmagic.Certificate) (*certmagic.Config, error) {
+			return magic, nil
+		},
+	})
+
+	id := s.ID
+	magic = certmagic.New(cache, certmagic.Config{
+		Storage: &certmagic.FileStorage{Path: s.ACMEStoragePath},
+		OnEvent: func(ctx context.Context, event string, data map[string]any) error {
+			if event == "cert_obtained" {
+				a.publish(EventCertificateIssued, id, fmt.Sprintf("certificate issued for %v", data["identifier"]), data["identifier"])
+			}
+			return nil
+		},
+	})
+	magic.Issuers = []certmagic.Issuer{certmagic.NewACMEIssuer(magic, certmagic.ACMEIssuer{
+		Email:  s.ACMECertEmail,
+		Agreed: true,
+	})}
+	return magic, cache
+}
+
+// defaultACMEStoragePath stores the certificates of servers without a
+// storage path in a "certs" directory next to the servers config file
+func (a *App) defaultACMEStoragePath(s *server.Server) {
+	if s.ACMEEnabled && s.ACMEStoragePath == "" {
+		s.ACMEStoragePath = filepath.Join(filepath.Dir(a.serversConfigPath), "certs")
+	}
+}
+
+func acmeSettings(s *server.Server) ACMESettings {
+	domains := s.ACMEDomains
+	if domains == nil {
+		domains = []string{}
+	}
+	return ACMESettings{
+		Enabled:     s.ACMEEnabled,
+		Email:       s.ACMECertEmail,
+		Domains:     domains,
+		StoragePath: s.ACMEStoragePath,
+	}
+}
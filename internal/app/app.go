@@ -2,368 +2,568 @@ package app
 
 import (
 	"context"
+	"crypto/cipher"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 
-	"github.com/caddyserver/certmagic"
-	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/yaml.v2"
-
+	"phpservermanager/internal/auth"
 	"phpservermanager/internal/config"
+	"phpservermanager/internal/logfile"
 	"phpservermanager/internal/server"
 )
 
 // App struct
 type App struct {
 	ctx               context.Context
+	cancel            context.CancelFunc
 	servers           map[string]*server.Server
 	nextID            int
 	mu                sync.Mutex
-	processes         map[string]*exec.Cmd
+	processes         map[string]*server.Process
 	serversConfigPath string
 	serverHost        string
 	serverPort        string
 	auth              config.Auth
-	certmagicInstances map[string]*certmagic.Config
+	acmeManagers      map[string]*acmeManager
+	publicIPs         []string
+	logs              map[string]*server.Logger
+	logDir            string
+	restarts          map[string]*restartState
+	orphanPolicy      string
+	allowShell        bool
+	portRange         config.PortRange
+	listenHost        string
+	listenPort        string
+	health            map[string]*healthState
+	metrics           map[string]*metricsState
+	users             map[string]*auth.User
+	tokens            map[string]*auth.Token
+	sessions          map[string]*session
+	sessionIdle       time.Duration
+	sessionAbsolute   time.Duration
+	events            *EventBus
+	secretOnce        sync.Once
+	secretAEAD        cipher.AEAD
+	secretErr         error
+	// secretsOnDisk is set once encrypted values were loaded, which must
+	// not be orphaned by generating a new secret key
+	secretsOnDisk      bool
+	unavailableSecrets map[string]map[string]string
+	auditLog           *logfile.Writer
+	auditMu            sync.Mutex
 }
 
 // NewApp creates a new App application struct
 func NewApp(cfg *config.Config) *App {
 	app := &App{
-		servers:           make(map[string]*server.Server),
-		nextID:            1,
-		processes:         make(map[string]*exec.Cmd),
-		serversConfigPath: cfg.ServersConfigPath,
-		serverHost:        cfg.Server.Host,
-		serverPort:        cfg.Server.Port,
-		auth:              cfg.Auth,
-		certmagicInstances: make(map[string]*certmagic.Config),
+		servers:            make(map[string]*server.Server),
+		nextID:             1,
+		processes:          make(map[string]*server.Process),
+		serversConfigPath:  cfg.ServersConfigPath,
+		serverHost:         cfg.Server.Host,
+		serverPort:         cfg.Server.Port,
+		auth:               cfg.Auth,
+		acmeManagers:       make(map[string]*acmeManager),
+		publicIPs:          cfg.PublicIPs,
+		logs:               make(map[string]*server.Logger),
+		logDir:             filepath.Join(filepath.Dir(cfg.ServersConfigPath), "logs"),
+		restarts:           make(map[string]*restartState),
+		orphanPolicy:       cfg.OrphanPolicy,
+		allowShell:         cfg.AllowShellCommands,
+		portRange:          cfg.AutoPortRange,
+		listenHost:         cfg.Server.Host,
+		listenPort:         cfg.Server.Port,
+		health:             make(map[string]*healthState),
+		metrics:            make(map[string]*metricsState),
+		users:              make(map[string]*auth.User),
+		tokens:             make(map[string]*auth.Token),
+		sessions:           make(map[string]*session),
+		sessionIdle:        time.Duration(cfg.Session.IdleTimeoutMinutes) * time.Minute,
+		sessionAbsolute:    time.Duration(cfg.Session.AbsoluteTimeoutHours) * time.Hour,
+		events:             NewEventBus(),
+		unavailableSecrets: make(map[string]map[string]string),
+		auditLog:           newAuditLog(cfg.ServersConfigPath, cfg.Audit.MaxSizeMB, cfg.Audit.MaxBackups),
+	}
+
+	if app.portRange.Start == 0 && app.portRange.End == 0 {
+		app.portRange = config.PortRange{Start: defaultAutoPortStart, End: defaultAutoPortEnd}
+	}
+
+	if app.sessionIdle <= 0 {
+		app.sessionIdle = defaultSessionIdleTimeout
+	}
+	if app.sessionAbsolute <= 0 {
+		app.sessionAbsolute = defaultSessionAbsoluteTimeout
 	}
 
-	
+	if cfg.CgroupRoot != "" {
+		server.CgroupRoot = cfg.CgroupRoot
+	}
+
+	if !server.ValidOrphanPolicy(app.orphanPolicy) {
+		fmt.Printf("Unknown orphan_policy %q, adopting orphaned processes instead\n", app.orphanPolicy)
+		app.orphanPolicy = server.OrphanAdopt
+	}
 
-    return app
+	return app
 }
 
 // Startup is called when the app starts
 func (a *App) Startup(ctx context.Context) {
-    a.ctx = ctx
-    // Ensure the directory for serversConfigPath exists
-    configDir := filepath.Dir(a.serversConfigPath)
-    if _, err := os.Stat(configDir); os.IsNotExist(err) {
-        os.MkdirAll(configDir, 0755)
-    }
-    a.loadConfig()
+	a.ctx, a.cancel = context.WithCancel(ctx)
+	// Ensure the directory for serversConfigPath exists
+	configDir := filepath.Dir(a.serversConfigPath)
+	if _, err := os.Stat(configDir); os.IsNotExist(err) {
+		os.MkdirAll(configDir, 0755)
+	}
+	a.loadConfig()
+	a.loadUsers()
+	a.loadTokens()
+	a.recoverProcesses()
+	go a.restoreServers()
+	go a.runHealthChecks()
+	go a.runMetrics()
 }
 
-// Shutdown is called when the app is about to exit
+// Shutdown is called when the app is about to exit. Servers are stopped
+// without changing their desired state, so they come back on the next start.
 func (a *App) Shutdown(ctx context.Context) {
-    for id, s := range a.servers {
-        if s.Running {
-            a.StopServer(id)
-        }
-    }
-    a.saveConfig()
+	if a.cancel != nil {
+		a.cancel()
+	}
+
+	a.mu.Lock()
+	var ids []string
+	for id, s := range a.servers {
+		if s.Running {
+			ids = append(ids, id)
+		}
+	}
+	a.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			a.stopServer(id)
+		}(id)
+	}
+	wg.Wait()
+
+	a.saveConfig()
+	a.closeLogs()
+	a.auditLog.Close()
 }
 
 // loadConfig loads the saved configuration from disk
 func (a *App) loadConfig() {
-    data, err := ioutil.ReadFile(a.serversConfigPath)
-    if err != nil {
-        return
-    }
-
-    var config struct {
-        Servers    map[string]*server.Server `json:"servers"`
-        NextID     int                       `json:"nextID"`
-        ServerHost string                    `json:"serverHost"`
-        ServerPort string                    `json:"serverPort"`
-    }
-    if err := json.Unmarshal(data, &config); err != nil {
-        fmt.Printf("Error loading configuration: %v\n", err)
-        return
-    }
-
-    a.servers = config.Servers
-    a.nextID = config.NextID
-    if config.ServerHost != "" {
-        a.serverHost = config.ServerHost
-    }
-    if config.ServerPort != "" {
-        a.serverPort = config.ServerPort
-    }
-
-    for _, s := range a.servers {
-        s.Running = false
-        if s.Host == "" {
-            s.Host = "localhost"
-        }
-    }
+	data, err := ioutil.ReadFile(a.serversConfigPath)
+	if err != nil {
+		return
+	}
+
+	var config struct {
+		Servers    map[string]*server.Server `json:"servers"`
+		NextID     int                       `json:"nextID"`
+		ServerHost string                    `json:"serverHost"`
+		ServerPort string                    `json:"serverPort"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		return
+	}
+
+	if config.Servers != nil {
+		a.servers = config.Servers
+	}
+	a.decryptServerSecrets(a.servers)
+	a.nextID = config.NextID
+	if config.ServerHost != "" {
+		a.serverHost = config.ServerHost
+	}
+	if config.ServerPort != "" {
+		a.serverPort = config.ServerPort
+	}
+
+	for _, s := range a.servers {
+		s.Running = false
+		s.State = server.StateStopped
+		if s.Host == "" {
+			s.Host = "localhost"
+		}
+		if s.MigrateCommand() {
+			if s.Shell {
+				fmt.Printf("Server %s: command uses shell syntax and now runs in shell mode\n", s.ID)
+			} else {
+				fmt.Printf("Server %s: command converted to args %q\n", s.ID, s.Args)
+			}
+		}
+	}
 }
 
 // saveConfig saves the current configuration to disk
 func (a *App) saveConfig() {
-    a.mu.Lock()
-    defer a.mu.Unlock()
-
-    config := struct {
-        Servers    map[string]*server.Server `json:"servers"`
-        NextID     int                       `json:"nextID"`
-        ServerHost string                    `json:"serverHost"`
-        ServerPort string                    `json:"serverPort"`
-    }{
-        Servers:    a.servers,
-        NextID:     a.nextID,
-        ServerHost: a.serverHost,
-        ServerPort: a.serverPort,
-    }
-
-    data, err := json.MarshalIndent(config, "", "  ")
-    if err != nil {
-        fmt.Printf("Error serializing configuration: %v\n", err)
-        return
-    }
-
-    if err := ioutil.WriteFile(a.serversConfigPath, data, 0644); err != nil {
-        fmt.Printf("Error saving configuration: %v\n", err)
-    }
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	servers, err := a.encryptServerSecrets(a.servers)
+	if err != nil {
+		fmt.Printf("Error encrypting secrets: %v\n", err)
+		return
+	}
+
+	config := struct {
+		Servers    map[string]*server.Server `json:"servers"`
+		NextID     int                       `json:"nextID"`
+		ServerHost string                    `json:"serverHost"`
+		ServerPort string                    `json:"serverPort"`
+	}{
+		Servers:    servers,
+		NextID:     a.nextID,
+		ServerHost: a.serverHost,
+		ServerPort: a.serverPort,
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		fmt.Printf("Error serializing configuration: %v\n", err)
+		return
+	}
+
+	if err := writeFileAtomic(a.serversConfigPath, data, 0644); err != nil {
+		fmt.Printf("Error saving configuration: %v\n", err)
+	}
+}
+
+// writeFileAtomic replaces the file at path by renaming a new file over it,
+// so that it is never seen half-written, e.g. after a crash or while it is
+// saved again in the background
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
 
 // GetServers returns all configured servers
 func (a *App) GetServers() []*server.Server {
-    a.mu.Lock()
-    defer a.mu.Unlock()
-
-    servers := make([]*server.Server, 0, len(a.servers))
-    for _, s := range a.servers {
-        servers = append(servers, s)
-    }
-    return servers
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	servers := make([]*server.Server, 0, len(a.servers))
+	for id, s := range a.servers {
+		c := a.maskServerSecrets(id, s.Clone())
+		if s.Running {
+			c.Metrics = a.metrics[id].latest()
+		}
+		servers = append(servers, c)
+	}
+	return servers
+}
+
+// GetServer returns a copy of a server configuration
+func (a *App) GetServer(id string) (server.Server, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, exists := a.servers[id]
+	if !exists {
+		return server.Server{}, false
+	}
+	return *a.maskServerSecrets(id, s.Clone()), true
+}
+
+// CreateServer adds a new server configuration using the settings of spec
+func (a *App) CreateServer(ctx context.Context, spec *server.Server) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.resolvePort("", spec); err != nil {
+		a.audit(ctx, AuditServerCreate, "", spec.Name, nil, err)
+		return "", err
+	}
+
+	id := strconv.Itoa(a.nextID)
+	a.nextID++
+
+	s := &server.Server{
+		ID:           id,
+		Running:      false,
+		State:        server.StateStopped,
+		DesiredState: server.StateStopped,
+	}
+	applySettings(s, spec)
+	a.defaultACMEStoragePath(s)
+
+	a.servers[id] = s
+	a.audit(ctx, AuditServerCreate, id, s.Name, auditServerDiff(nil, s), nil)
+	a.publish(EventCreated, id, "", nil)
+	go a.saveConfig()
+	return id, nil
 }
 
-// CreateServer adds a new server configuration
-func (a *App) CreateServer(name, host, port, directory, command string) string {
-    a.mu.Lock()
-    defer a.mu.Unlock()
-
-    id := strconv.Itoa(a.nextID)
-    a.nextID++
-
-    if host == "" {
-        host = "localhost"
-    }
-
-    s := &server.Server{
-        ID:        id,
-        Name:      name,
-        Host:      host,
-        Port:      port,
-        Directory: directory,
-        Command:   command,
-        Running:   false,
-    }
-
-    a.servers[id] = s
-    go a.saveConfig()
-    return id
+// UpdateServer updates an existing server configuration with the settings of spec
+func (a *App) UpdateServer(ctx context.Context, id string, spec *server.Server) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, exists := a.servers[id]
+	if !exists {
+		return ErrServerNotFound
+	}
+
+	if err := a.resolvePort(id, spec); err != nil {
+		a.audit(ctx, AuditServerUpdate, id, s.Name, nil, err)
+		return err
+	}
+
+	if s.Running {
+		a.mu.Unlock()
+		a.haltServer(id)
+		a.mu.Lock()
+	}
+
+	before := s.Clone()
+	applySettings(s, spec)
+	a.updateUnavailableSecrets(id, spec.Secrets)
+	a.defaultACMEStoragePath(s)
+	a.audit(ctx, AuditServerUpdate, id, s.Name, auditServerDiff(before, s), nil)
+	a.publish(EventUpdated, id, "", nil)
+	go a.saveConfig()
+	return nil
 }
 
-// UpdateServer updates an existing server configuration
-func (a *App) UpdateServer(id, name, host, port, directory, command string) bool {
-    a.mu.Lock()
-    defer a.mu.Unlock()
-
-    s, exists := a.servers[id]
-    if !exists {
-        return false
-    }
-
-    if s.Running {
-        a.mu.Unlock()
-        a.StopServer(id)
-        a.mu.Lock()
-    }
-
-    if host == "" {
-        host = "localhost"
-    }
-
-    s.Name = name
-    s.Host = host
-    s.Port = port
-    s.Directory = directory
-    s.Command = command
-    go a.saveConfig()
-    return true
+// applySettings copies the user configurable settings of spec onto s,
+// leaving its identity and runtime state untouched
+func applySettings(s, spec *server.Server) {
+	s.Name = spec.Name
+	s.Host = spec.Host
+	if s.Host == "" {
+		s.Host = "localhost"
+	}
+	s.Port = spec.Port
+	s.Directory = spec.Directory
+	s.Command = spec.Command
+	s.Args = spec.Args
+	s.Shell = spec.Shell
+	s.RestartPolicy = spec.RestartPolicy
+	if s.RestartPolicy == "" {
+		s.RestartPolicy = server.RestartNever
+	}
+	s.MaxRetries = spec.MaxRetries
+	s.RetryWindow = spec.RetryWindow
+	s.StopTimeout = spec.StopTimeout
+	s.Autostart = spec.Autostart
+	s.StartOrder = spec.StartOrder
+	s.StartDelay = spec.StartDelay
+	s.HealthCheck = nil
+	if spec.HealthCheck != nil {
+		hc := *spec.HealthCheck
+		s.HealthCheck = &hc
+	}
+	s.Env = spec.Env
+	s.Secrets = mergeSecrets(s.Secrets, spec.Secrets)
+	s.LoadEnvFile = spec.LoadEnvFile
+	s.RunAsUser = spec.RunAsUser
+	s.RunAsGroup = spec.RunAsGroup
+	s.Limits = nil
+	if spec.Limits != nil {
+		l := *spec.Limits
+		s.Limits = &l
+	}
+	s.Owner = spec.Owner
+	s.ACL = spec.ACL
+	s.ACMEEnabled = spec.ACMEEnabled
+	s.ACMECertEmail = spec.ACMECertEmail
+	s.ACMEDomains = spec.ACMEDomains
+	s.ACMEStoragePath = spec.ACMEStoragePath
 }
 
 // DeleteServer removes a server configuration
-func (a *App) DeleteServer(id string) bool {
-    a.mu.Lock()
-    defer a.mu.Unlock()
-
-    s, exists := a.servers[id]
-    if !exists {
-        return false
-    }
-
-    if s.Running {
-        a.mu.Unlock()
-        a.StopServer(id)
-        a.mu.Lock()
-    }
-
-    delete(a.servers, id)
-    go a.saveConfig()
-    return true
+func (a *App) DeleteServer(ctx context.Context, id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, exists := a.servers[id]
+	if !exists {
+		return false
+	}
+
+	if s.Running {
+		a.mu.Unlock()
+		a.haltServer(id)
+		a.mu.Lock()
+	}
+
+	a.stopACME(id)
+	delete(a.servers, id)
+	delete(a.unavailableSecrets, id)
+	a.audit(ctx, AuditServerDelete, id, s.Name, auditServerDiff(s, nil), nil)
+	delete(a.metrics, id)
+	if st, ok := a.restarts[id]; ok {
+		if st.timer != nil {
+			st.timer.Stop()
+		}
+		delete(a.restarts, id)
+	}
+	if logger, ok := a.logs[id]; ok {
+		logger.Close()
+		delete(a.logs, id)
+	}
+	a.publish(EventDeleted, id, "", nil)
+	go a.saveConfig()
+	return true
 }
 
 // UpdateServerSettings updates the management server host and port
-func (a *App) UpdateServerSettings(host, port string) bool {
-    a.mu.Lock()
-    defer a.mu.Unlock()
-
-    if host == "" {
-        host = "localhost"
-    }
-    if port == "" {
-        port = "8080"
-    }
-
-    a.serverHost = host
-    a.serverPort = port
-    go a.saveConfig()
-    return true
+func (a *App) UpdateServerSettings(ctx context.Context, host, port string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "8080"
+	}
+
+	type settings struct {
+		Host string `json:"host"`
+		Port string `json:"port"`
+	}
+	changes := auditDiff(&settings{a.serverHost, a.serverPort}, &settings{host, port})
+	a.serverHost = host
+	a.serverPort = port
+	a.audit(ctx, AuditSettingsUpdate, "", "", changes, nil)
+	go a.saveConfig()
+	return true
+}
+
+// ShellCommandsAllowed reports whether servers may run in shell mode
+func (a *App) ShellCommandsAllowed() bool {
+	return a.allowShell
 }
 
 // GetServerSettings returns the current server settings
 func (a *App) GetServerSettings() (string, string) {
-    a.mu.Lock()
-    defer a.mu.Unlock()
-    return a.serverHost, a.serverPort
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.serverHost, a.serverPort
 }
 
-// StartServer starts a PHP server
-func (a *App) StartServer(id string) bool {
-    a.mu.Lock()
-    s, exists := a.servers[id]
-    if !exists || s.Running {
-        a.mu.Unlock()
-        return false
-    }
-    a.mu.Unlock()
-
-    if s.ACMEEnabled && len(s.ACMEDomains) > 0 {
-        cfg := certmagic.NewDefault()
-        cfg.Storage = &certmagic.FileStorage{Path: s.ACMEStoragePath}
-
-        // Manage certificates in a goroutine to avoid blocking
-        go func() {
-            err := cfg.ManageSync(a.ctx, s.ACMEDomains)
-            if err != nil {
-                fmt.Printf("CertMagic error for server %s (%s): %v\n", s.Name, s.ID, err)
-            }
-        }()
-        a.mu.Lock()
-        a.certmagicInstances[s.ID] = cfg
-        a.mu.Unlock()
-    }
-
-    return server.Start(s, a.processes, &a.mu)
+// StartServer starts a PHP server and records that it should be running
+func (a *App) StartServer(ctx context.Context, id string) bool {
+	a.resetRestarts(id)
+	if !a.startServer(id) {
+		a.audit(ctx, AuditServerStart, id, "", nil, errStartFailed)
+		return false
+	}
+	a.setDesiredState(id, server.StateRunning)
+	a.audit(ctx, AuditServerStart, id, "", nil, nil)
+	return true
 }
 
-// StopServer stops a running PHP server
-func (a *App) StopServer(id string) bool {
-    a.mu.Lock()
-    s, exists := a.servers[id]
-    if !exists || !s.Running {
-        a.mu.Unlock()
-        return false
-    }
-    a.mu.Unlock()
-
-    if s.ACMEEnabled {
-        if _, ok := a.certmagicInstances[s.ID]; ok {
-            // Stop the certificate management for this server
-            // This is a conceptual representation. The actual implementation might vary based on certmagic's API.
-            // certmagic.Default.Unmanage(s.ACMEDomains)
-            // For now, we'll just log it.
-            fmt.Printf("Stopping cert management for %s\n", s.ID)
-            delete(a.certmagicInstances, s.ID)
-        }
-    }
-
-    return server.Stop(s, a.processes, &a.mu)
-}
+// startServer starts a PHP server without touching its restart state
+func (a *App) startServer(id string) bool {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists || s.Running {
+		a.mu.Unlock()
+		return false
+	}
+	if s.Shell && !a.allowShell {
+		a.mu.Unlock()
+		a.logger(id).System("shell commands are disabled, set allow_shell_commands in config.yaml to run this server")
+		return false
+	}
+	host, port := s.Host, s.Port
+	a.mu.Unlock()
 
-// GetServerStatus returns the status of a specific server
-func (a *App) GetServerStatus(id string) (bool, bool) {
-    a.mu.Lock()
-    defer a.mu.Unlock()
+	if err := server.PortAvailable(host, port); err != nil {
+		a.logger(id).System("port %s is not available: %v", port, err)
+		a.publish(EventCrashed, id, "port not available", nil)
+		return false
+	}
 
-    s, exists := a.servers[id]
-    if !exists {
-        return false, false
-    }
-    return true, s.Running
+	a.publish(EventStarting, id, "", nil)
+	if !server.Start(s, a.processes, &a.mu, a.logger(id), a.exitHandler(id)) {
+		a.publish(EventCrashed, id, "failed to start", nil)
+		return false
+	}
+	a.publish(EventStarted, id, "", nil)
+	a.startACME(id)
+	go a.saveConfig()
+	return true
 }
 
-// UpdateAuth updates the auth settings in the config file
-func (a *App) UpdateAuth(username, password string) error {
-    a.mu.Lock()
-    defer a.mu.Unlock()
-
-    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-    if err != nil {
-        return fmt.Errorf("failed to hash password: %w", err)
-    }
-
-    a.auth.Username = username
-    a.auth.PasswordHash = string(hashedPassword)
-
-    // read the config file
-    data, err := ioutil.ReadFile("internal/config/config.yaml")
-    if err != nil {
-        return err
-    }
-
-    // unmarshal the config file
-    var configData map[string]interface{}
-    if err := yaml.Unmarshal(data, &configData); err != nil {
-        return err
-    }
-
-    // update the auth settings
-    authData, ok := configData["auth"].(map[interface{}]interface{})
-    if !ok {
-        authData = make(map[interface{}]interface{})
-    }
-    authData["username"] = username
-    authData["password_hash"] = string(hashedPassword)
-    configData["auth"] = authData
-
-    // marshal the config file
-    newData, err := yaml.Marshal(&configData)
-    if err != nil {
-        return err
-    }
-
-    // write the config file
-    if err := ioutil.WriteFile("internal/config/config.yaml", newData, 0644); err != nil {
-        return err
-    }
-
-    return nil
+// StopServer stops a running PHP server and records that it should stay stopped
+func (a *App) StopServer(ctx context.Context, id string) bool {
+	if !a.haltServer(id) {
+		a.audit(ctx, AuditServerStop, id, "", nil, errStopFailed)
+		return false
+	}
+	a.audit(ctx, AuditServerStop, id, "", nil, nil)
+	return true
 }
 
+// haltServer stops a running PHP server and records that it should stay
+// stopped, as StopServer does without an audit entry
+func (a *App) haltServer(id string) bool {
+	a.resetRestarts(id)
+	a.setDesiredState(id, server.StateStopped)
+	return a.stopServer(id)
+}
 
+// stopServer stops a running PHP server without touching its desired state
+func (a *App) stopServer(id string) bool {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists || !s.Running || s.State == server.StateStopping {
+		a.mu.Unlock()
+		return false
+	}
+	a.stopACME(id)
+	a.mu.Unlock()
 
+	return server.Stop(s, a.processes, &a.mu, a.logger(id))
+}
 
+// GetServerStatus returns the runtime status of a specific server
+func (a *App) GetServerStatus(id string) (server.Status, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-
+	s, exists := a.servers[id]
+	if !exists {
+		return server.Status{}, false
+	}
+	status := s.Status()
+	if proc, running := a.processes[id]; running {
+		status.Usage = proc.Usage()
+	}
+	if st := a.metrics[id]; s.Running && st != nil {
+		status.Metrics = st.latest()
+		status.History = append([]server.ProcessMetrics(nil), st.history...)
+	}
+	return status, true
+}
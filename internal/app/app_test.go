@@ -0,0 +1,22 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"phpservermanager/internal/config"
+)
+
+// newTestApp returns an app whose files are kept in a temporary directory
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	// Files are saved in the background, so the directory may still be
+	// written to when the test ends
+	dir, err := os.MkdirTemp("", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return NewApp(&config.Config{ServersConfigPath: filepath.Join(dir, "servers.json")})
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/logfile"
+	"phpservermanager/internal/server"
+)
+
+const (
+	auditFile              = "audit.log"
+	defaultAuditMaxSizeMB  = 10
+	defaultAuditMaxBackups = 5
+)
+
+// Audited actions
+const (
+	AuditServerCreate   = "server.create"
+	AuditServerUpdate   = "server.update"
+	AuditServerDelete   = "server.delete"
+	AuditServerStart    = "server.start"
+	AuditServerStop     = "server.stop"
+	AuditSettingsUpdate = "settings.update"
+	AuditUserCreate     = "user.create"
+	AuditUserUpdate     = "user.update"
+	AuditUserDelete     = "user.delete"
+	AuditAuthUpdate     = "auth.update"
+	AuditTokenCreate    = "token.create"
+	AuditTokenDelete    = "token.delete"
+	AuditTOTPEnable     = "totp.enable"
+	AuditTOTPDisable    = "totp.disable"
+	AuditACMEUpdate     = "acme.update"
+	AuditACMERenew      = "acme.renew"
+	AuditACMERevoke     = "acme.revoke"
+)
+
+// Errors recorded for start and stop requests that didn't succeed. The
+// server log has the details.
+var (
+	errStartFailed = errors.New("server failed to start")
+	errStopFailed  = errors.New("server was not running")
+)
+
+// auditIgnored are the server fields that describe runtime state rather
+// than configuration, left out of the diffs
+var auditIgnored = map[string]bool{
+	"running": true, "state": true, "desired_state": true, "pid": true, "proc_start": true,
+	"started_at": true, "restart_count": true, "last_exit_code": true, "last_exit_reason": true,
+	"last_exit_at": true, "health": true, "health_message": true, "last_health_check": true,
+	"metrics": true, "permissions": true,
+}
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Time     time.Time              `json:"time"`
+	Actor    string                 `json:"actor"`
+	SourceIP string                 `json:"source_ip,omitempty"`
+	Action   string                 `json:"action"`
+	ServerID string                 `json:"server_id,omitempty"`
+	Target   string                 `json:"target,omitempty"`
+	Changes  map[string]AuditChange `json:"changes,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// AuditChange is the value of a field before and after an action
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditFilter selects audit entries. Empty fields match everything; Action
+// also matches by prefix when it ends in ".", e.g. "server.".
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	ServerID string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// newAuditLog opens the audit log next to the servers config file
+func newAuditLog(serversConfigPath string, maxSizeMB, maxBackups int) *logfile.Writer {
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultAuditMaxSizeMB
+	}
+	if maxBackups <= 0 {
+		maxBackups = defaultAuditMaxBackups
+	}
+	path := filepath.Join(filepath.Dir(serversConfigPath), auditFile)
+	return logfile.New(path, int64(maxSizeMB)<<20, maxBackups)
+}
+
+// audit records an action by the user in ctx, with err for actions that
+// failed
+func (a *App) audit(ctx context.Context, action, serverID, target string, changes map[string]AuditChange, err error) {
+	e := AuditEntry{
+		Time:     time.Now().UTC(),
+		Actor:    "system",
+		SourceIP: auth.ClientIP(ctx),
+		Action:   action,
+		ServerID: serverID,
+		Target:   target,
+		Changes:  changes,
+	}
+	if u, ok := auth.FromContext(ctx); ok {
+		e.Actor = u.Username
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	line, jerr := json.Marshal(e)
+	if jerr != nil {
+		fmt.Printf("Error serializing audit entry: %v\n", jerr)
+		return
+	}
+
+	a.auditMu.Lock()
+	defer a.auditMu.Unlock()
+	if _, werr := a.auditLog.Write(append(line, '\n')); werr != nil {
+		fmt.Printf("Error writing audit log: %v\n", werr)
+	}
+}
+
+// GetAudit returns the audit entries matching f, newest first. Rotated
+// files are searched too.
+func (a *App) GetAudit(f AuditFilter) ([]AuditEntry, error) {
+	a.auditMu.Lock()
+	defer a.auditMu.Unlock()
+
+	entries := []AuditEntry{}
+	path := a.auditLog.Path()
+	for i := 0; ; i++ {
+		p := path
+		if i > 0 {
+			p = fmt.Sprintf("%s.%d", path, i)
+		}
+		file, err := os.Open(p)
+		if os.IsNotExist(err) {
+			if i == 0 {
+				continue
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Each file is read oldest first, so collect it and prepend
+		var matched []AuditEntry
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var e AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil && f.matches(e) {
+				matched = append(matched, e)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for j := len(matched) - 1; j >= 0; j-- {
+			entries = append(entries, matched[j])
+			if f.Limit > 0 && len(entries) >= f.Limit {
+				return entries, nil
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (f AuditFilter) matches(e AuditEntry) bool {
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action && !(strings.HasSuffix(f.Action, ".") && strings.HasPrefix(e.Action, f.Action)) {
+		return false
+	}
+	if f.ServerID != "" && e.ServerID != f.ServerID {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// auditDiff returns the fields that differ between two values as they are
+// serialized to JSON. Either value may be nil, for created or deleted
+// objects.
+func auditDiff(before, after interface{}) map[string]AuditChange {
+	b, a := auditFields(before), auditFields(after)
+	changes := make(map[string]AuditChange)
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			changes[k] = AuditChange{Before: v, After: a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = AuditChange{Before: nil, After: v}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// auditServerDiff describes the changes to a server's configuration.
+// Secret values are masked, so changing one shows as masked values.
+func auditServerDiff(before, after *server.Server) map[string]AuditChange {
+	var b, a *server.Server
+	if before != nil {
+		b = maskSecrets(before.Clone())
+	}
+	if after != nil {
+		a = maskSecrets(after.Clone())
+	}
+	changes := auditDiff(b, a)
+	if before != nil && after != nil && !reflect.DeepEqual(before.Secrets, after.Secrets) {
+		if _, ok := changes["secrets"]; !ok {
+			if changes == nil {
+				changes = make(map[string]AuditChange)
+			}
+			changes["secrets"] = AuditChange{Before: b.Secrets, After: a.Secrets}
+		}
+	}
+	return changes
+}
+
+func auditFields(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if rv := reflect.ValueOf(v); !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	for k := range auditIgnored {
+		delete(fields, k)
+	}
+	return fields
+}
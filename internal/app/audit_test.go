@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/server"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := &server.Server{ID: "1", Name: "blog", Port: "8000", Running: true, Pid: 42}
+	after := &server.Server{ID: "1", Name: "blog", Port: "8001"}
+
+	// Runtime state isn't configuration and is left out
+	changes := auditDiff(before, after)
+	if len(changes) != 1 || changes["port"] != (AuditChange{Before: "8000", After: "8001"}) {
+		t.Errorf("auditDiff = %v, want only the port", changes)
+	}
+	if changes := auditDiff(before, before.Clone()); changes != nil {
+		t.Errorf("unchanged server: auditDiff = %v, want nil", changes)
+	}
+
+	created := auditDiff(nil, after)
+	if created["name"] != (AuditChange{Before: nil, After: "blog"}) {
+		t.Errorf("created server: name change = %v", created["name"])
+	}
+	if _, ok := created["running"]; ok {
+		t.Error("created server: runtime state in diff")
+	}
+	deleted := auditDiff(before, (*server.Server)(nil))
+	if deleted["port"] != (AuditChange{Before: "8000", After: nil}) {
+		t.Errorf("deleted server: port change = %v", deleted["port"])
+	}
+}
+
+func TestAuditServerDiffMasksSecrets(t *testing.T) {
+	before := &server.Server{ID: "1", Secrets: map[string]string{"DB_PASSWORD": "old-secret"}}
+	after := &server.Server{ID: "1", Secrets: map[string]string{"DB_PASSWORD": "new-secret"}}
+
+	changes := auditServerDiff(before, after)
+	if _, ok := changes["secrets"]; !ok {
+		t.Fatalf("changed secret isn't recorded: %v", changes)
+	}
+	data, _ := json.Marshal(changes)
+	if strings.Contains(string(data), "old-secret") || strings.Contains(string(data), "new-secret") || !strings.Contains(string(data), SecretMask) {
+		t.Errorf("secret values in diff: %s", data)
+	}
+	if before.Secrets["DB_PASSWORD"] != "old-secret" {
+		t.Error("auditServerDiff modified the server")
+	}
+}
+
+func TestGetAudit(t *testing.T) {
+	a := newTestApp(t)
+	alice := auth.NewContext(context.Background(), &auth.User{Username: "alice"})
+	bob := auth.NewClientIPContext(auth.NewContext(context.Background(), &auth.User{Username: "bob"}), "192.0.2.1")
+
+	// An older, rotated file
+	old := AuditEntry{Time: time.Now().UTC().Add(-time.Hour), Actor: "alice", Action: AuditUserCreate, Target: "bob"}
+	line, _ := json.Marshal(old)
+	if err := os.WriteFile(a.auditLog.Path()+".1", append(line, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a.audit(alice, AuditServerCreate, "1", "", nil, nil)
+	a.audit(bob, AuditServerStart, "1", "", nil, errStartFailed)
+	a.audit(bob, AuditServerStop, "2", "", nil, nil)
+	a.audit(context.Background(), AuditSettingsUpdate, "", "", nil, nil)
+
+	actions := func(f AuditFilter) string {
+		entries, err := a.GetAudit(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Action)
+		}
+		return strings.Join(got, ",")
+	}
+
+	tests := []struct {
+		filter AuditFilter
+		want   string
+	}{
+		{AuditFilter{}, "settings.update,server.stop,server.start,server.create,user.create"},
+		{AuditFilter{Limit: 2}, "settings.update,server.stop"},
+		{AuditFilter{Actor: "alice"}, "server.create,user.create"},
+		{AuditFilter{Actor: "system"}, "settings.update"},
+		{AuditFilter{Action: "server."}, "server.stop,server.start,server.create"},
+		{AuditFilter{Action: "server"}, ""},
+		{AuditFilter{ServerID: "1"}, "server.start,server.create"},
+		{AuditFilter{Until: time.Now().Add(-time.Minute)}, "user.create"},
+		{AuditFilter{Since: time.Now().Add(-time.Minute), Actor: "alice"}, "server.create"},
+	}
+	for _, tt := range tests {
+		if got := actions(tt.filter); got != tt.want {
+			t.Errorf("GetAudit(%+v) = %s, want %s", tt.filter, got, tt.want)
+		}
+	}
+
+	entries, _ := a.GetAudit(AuditFilter{Action: AuditServerStart})
+	if len(entries) != 1 || entries[0].SourceIP != "192.0.2.1" || entries[0].Error != errStartFailed.Error() {
+		t.Errorf("failed start entry = %+v", entries)
+	}
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+
+	"phpservermanager/internal/server"
+)
+
+// Certificate describes a certificate stored for one of a server's ACME
+// domains. Issuer is the ACME directory it came from, IssuerName the common
+// name of the CA certificate that signed it.
+type Certificate struct {
+	Domain     string    `json:"domain"`
+	Issuer     string    `json:"issuer"`
+	IssuerName string    `json:"issuer_name"`
+	SANs       []string  `json:"sans"`
+	Serial     string    `json:"serial"`
+	NotBefore  time.Time `json:"not_before"`
+	NotAfter   time.Time `json:"not_after"`
+}
+
+// GetCertificates returns the certificates in the ACME storage of a server,
+// one per domain and issuer
+func (a *App) GetCertificates(ctx context.Context, id string) ([]Certificate, bool) {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists {
+		a.mu.Unlock()
+		return nil, false
+	}
+	snapshot := s.Clone()
+	a.mu.Unlock()
+
+	return loadCertificates(ctx, snapshot), true
+}
+
+// loadCertificates reads the certificates of the ACME domains of s from its
+// certmagic storage
+func loadCertificates(ctx context.Context, s *server.Server) []Certificate {
+	if s.ACMEStoragePath == "" || len(s.ACMEDomains) == 0 {
+		return nil
+	}
+
+	storage := &certmagic.FileStorage{Path: s.ACMEStoragePath}
+	issuers, err := storage.List(ctx, "certificates", false)
+	if err != nil {
+		return nil
+	}
+
+	var certs []Certificate
+	for _, issuer := range issuers {
+		for _, domain := range s.ACMEDomains {
+			name := certmagic.StorageKeys.Safe(domain)
+			data, err := storage.Load(ctx, path.Join(issuer, name, name+".crt"))
+			if err != nil {
+				continue
+			}
+			block, _ := pem.Decode(data)
+			if block == nil {
+				continue
+			}
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				continue
+			}
+			certs = append(certs, Certificate{
+				Domain:     domain,
+				Issuer:     path.Base(issuer),
+				IssuerName: leaf.Issuer.CommonName,
+				SANs:       leaf.DNSNames,
+				Serial:     fmt.Sprintf("%X", leaf.SerialNumber),
+				NotBefore:  leaf.NotBefore,
+				NotAfter:   leaf.NotAfter,
+			})
+		}
+	}
+
+	sort.Slice(certs, func(i, j int) bool {
+		if certs[i].Domain != certs[j].Domain {
+			return certs[i].Domain < certs[j].Domain
+		}
+		return certs[i].Issuer < certs[j].Issuer
+	})
+	return certs
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// Server lifecycle event types
+const (
+	EventCreated           = "created"
+	EventUpdated           = "updated"
+	EventDeleted           = "deleted"
+	EventStarting          = "starting"
+	EventStarted           = "started"
+	EventStopped           = "stopped"
+	EventCrashed           = "crashed"
+	EventHealthChanged     = "health_changed"
+	EventCertificateIssued = "certificate_issued"
+)
+
+// Event is a change to a managed server published on the event bus
+type Event struct {
+	ID       uint64      `json:"id"`
+	Type     string      `json:"type"`
+	ServerID string      `json:"server_id,omitempty"`
+	Time     time.Time   `json:"time"`
+	Message  string      `json:"message,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// EventBus fans out events to subscribers. Publishing never blocks: a
+// subscriber that falls behind misses events.
+type EventBus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	subscribers map[*subscription]struct{}
+}
+
+type subscription struct {
+	ch        chan Event
+	serverIDs map[string]bool
+}
+
+// NewEventBus creates an empty event bus
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*subscription]struct{})}
+}
+
+// Publish assigns e an ID and timestamp and delivers it to every matching
+// subscriber
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for sub := range b.subscribers {
+		if len(sub.serverIDs) > 0 && !sub.serverIDs[e.ServerID] {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving events for the given servers (all
+// servers if none are given) and a function to cancel the subscription
+func (b *EventBus) Subscribe(serverIDs ...string) (<-chan Event, func()) {
+	sub := &subscription{
+		ch:        make(chan Event, 64),
+		serverIDs: make(map[string]bool),
+	}
+	for _, id := range serverIDs {
+		sub.serverIDs[id] = true
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// SubscribeEvents subscribes to server lifecycle events, optionally limited
+// to the given server IDs
+func (a *App) SubscribeEvents(serverIDs ...string) (<-chan Event, func()) {
+	return a.events.Subscribe(serverIDs...)
+}
+
+// publish publishes an event about a server
+func (a *App) publish(eventType, serverID, message string, data interface{}) {
+	a.events.Publish(Event{
+		Type:     eventType,
+		ServerID: serverID,
+		Message:  message,
+		Data:     data,
+	})
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"time"
+
+	"phpservermanager/internal/server"
+)
+
+const healthTick = time.Second
+
+// healthState tracks the health checks of one server process
+type healthState struct {
+	pid      int
+	next     time.Time
+	failures int
+	checking bool
+}
+
+// runHealthChecks periodically probes every running server that has a
+// health check configured, until the app shuts down
+func (a *App) runHealthChecks() {
+	ticker := time.NewTicker(healthTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.scheduleHealthChecks(now)
+		}
+	}
+}
+
+// scheduleHealthChecks starts the checks that are due. The first check of a
+// new process waits one interval to give it time to come up.
+func (a *App) scheduleHealthChecks(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id, s := range a.servers {
+		if !s.Running || s.HealthCheck == nil {
+			delete(a.health, id)
+			s.Health = ""
+			s.HealthMessage = ""
+			continue
+		}
+		if s.State != server.StateRunning {
+			continue
+		}
+
+		st := a.health[id]
+		if st == nil || st.pid != s.Pid {
+			st = &healthState{pid: s.Pid, next: now.Add(s.HealthCheck.IntervalDuration())}
+			a.health[id] = st
+			s.Health = server.HealthUnknown
+			s.HealthMessage = ""
+		}
+		if st.checking || now.Before(st.next) {
+			continue
+		}
+
+		st.checking = true
+		go a.checkHealth(id, st, s.Clone())
+	}
+}
+
+// checkHealth runs one health check against a snapshot of a server and
+// records the result, restarting the server if it just became unhealthy and
+// asks for it
+func (a *App) checkHealth(id string, st *healthState, snapshot *server.Server) {
+	err := server.CheckHealth(a.ctx, snapshot)
+	now := time.Now()
+
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists || a.health[id] != st {
+		a.mu.Unlock()
+		return
+	}
+
+	st.checking = false
+	st.next = now.Add(snapshot.HealthCheck.IntervalDuration())
+	s.LastHealthCheck = &now
+
+	previous := s.Health
+	restart := false
+	if err == nil {
+		st.failures = 0
+		s.Health = server.HealthHealthy
+		s.HealthMessage = ""
+	} else {
+		st.failures++
+		s.HealthMessage = err.Error()
+		if st.failures >= snapshot.HealthCheck.Threshold() && previous != server.HealthUnhealthy {
+			s.Health = server.HealthUnhealthy
+			restart = snapshot.HealthCheck.RestartOnFailure
+		}
+	}
+	current, message := s.Health, s.HealthMessage
+	a.mu.Unlock()
+
+	if current != previous {
+		a.publish(EventHealthChanged, id, message, map[string]string{"from": previous, "to": current})
+		if message != "" {
+			a.logger(id).System("health changed from %s to %s: %s", previous, current, message)
+		} else {
+			a.logger(id).System("health changed from %s to %s", previous, current)
+		}
+	}
+
+	if restart {
+		go a.restartUnhealthy(id)
+	}
+}
+
+// restartUnhealthy restarts a server that failed its health check
+func (a *App) restartUnhealthy(id string) {
+	a.logger(id).System("restarting unhealthy server")
+	a.stopServer(id)
+
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if exists {
+		s.RestartCount++
+	}
+	a.mu.Unlock()
+
+	if exists {
+		a.startServer(id)
+	}
+}
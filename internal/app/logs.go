@@ -0,0 +1,58 @@
+package app
+
+import (
+	"path/filepath"
+
+	"phpservermanager/internal/server"
+)
+
+// logger returns the output logger for a server, creating it on first use.
+// Log files live in a "logs" directory next to the servers config file.
+func (a *App) logger(id string) *server.Logger {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	l, ok := a.logs[id]
+	if !ok {
+		l = server.NewLogger(filepath.Join(a.logDir, id+".log"), server.DefaultLogLines)
+		a.logs[id] = l
+	}
+	return l
+}
+
+// GetServerLogs returns up to the last n captured output lines of a server
+func (a *App) GetServerLogs(id string, n int) ([]server.LogLine, bool) {
+	a.mu.Lock()
+	_, exists := a.servers[id]
+	a.mu.Unlock()
+	if !exists {
+		return nil, false
+	}
+	return a.logger(id).Tail(n), true
+}
+
+// SubscribeServerLogs returns the last n lines of a server's output together
+// with a channel receiving new lines as they are written. The returned
+// function must be called to release the subscription.
+func (a *App) SubscribeServerLogs(id string, n int) ([]server.LogLine, <-chan server.LogLine, func(), bool) {
+	a.mu.Lock()
+	_, exists := a.servers[id]
+	a.mu.Unlock()
+	if !exists {
+		return nil, nil, nil, false
+	}
+
+	l := a.logger(id)
+	ch, cancel := l.Subscribe()
+	return l.Tail(n), ch, cancel, true
+}
+
+// closeLogs closes every open server log file
+func (a *App) closeLogs() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, l := range a.logs {
+		l.Close()
+	}
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"time"
+
+	"phpservermanager/internal/server"
+)
+
+const (
+	metricsInterval = 5 * time.Second
+	// metricsHistory is the number of samples kept per server, ten minutes
+	// at the default interval
+	metricsHistory = 120
+)
+
+// metricsState holds the samples taken from one server process
+type metricsState struct {
+	pid     int
+	usage   server.GroupUsage
+	at      time.Time
+	history []server.ProcessMetrics
+}
+
+// latest returns the most recent sample, or nil before the first one
+func (st *metricsState) latest() *server.ProcessMetrics {
+	if st == nil || len(st.history) == 0 {
+		return nil
+	}
+	m := st.history[len(st.history)-1]
+	return &m
+}
+
+// runMetrics periodically samples the process group of every running
+// server, until the app shuts down
+func (a *App) runMetrics() {
+	ticker := time.NewTicker(metricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			a.sampleMetrics()
+		}
+	}
+}
+
+// sampleMetrics reads /proc for every running server. The first sample of a
+// new process has no CPU percentage, as that needs two readings.
+func (a *App) sampleMetrics() {
+	type target struct {
+		id        string
+		pid       int
+		startedAt *time.Time
+	}
+
+	a.mu.Lock()
+	var targets []target
+	for id, s := range a.servers {
+		if !s.Running || s.Pid == 0 {
+			delete(a.metrics, id)
+			continue
+		}
+		targets = append(targets, target{id, s.Pid, s.StartedAt})
+	}
+	a.mu.Unlock()
+
+	for _, t := range targets {
+		usage, err := server.ProcessGroupUsage(t.pid)
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+
+		a.mu.Lock()
+		if s, exists := a.servers[t.id]; !exists || s.Pid != t.pid {
+			a.mu.Unlock()
+			continue
+		}
+		st := a.metrics[t.id]
+		if st == nil || st.pid != t.pid {
+			st = &metricsState{pid: t.pid}
+			a.metrics[t.id] = st
+		}
+
+		m := server.ProcessMetrics{
+			Time:     now,
+			RSSBytes: usage.RSSBytes,
+			OpenFDs:  usage.OpenFDs,
+			Children: usage.Processes - 1,
+		}
+		if t.startedAt != nil {
+			m.Uptime = int64(now.Sub(*t.startedAt).Seconds())
+		}
+		if !st.at.IsZero() {
+			m.CPUPercent = usage.CPUPercent(st.usage, now.Sub(st.at))
+		}
+
+		st.usage = usage
+		st.at = now
+		st.history = append(st.history, m)
+		if len(st.history) > metricsHistory {
+			st.history = st.history[len(st.history)-metricsHistory:]
+		}
+		a.mu.Unlock()
+	}
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"time"
+
+	"phpservermanager/internal/auth"
+)
+
+// LoginOIDC signs in a user authenticated by the identity provider. Users
+// are created on their first login, and their role and groups follow the
+// provider on every login. Local users with the same name are never taken
+// over.
+func (a *App) LoginOIDC(username, role string, groups []string) (*auth.User, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if exists && u.Provider != auth.ProviderOIDC {
+		return nil, ErrUserExists
+	}
+	if !exists {
+		u = &auth.User{Username: username, Provider: auth.ProviderOIDC, CreatedAt: time.Now()}
+		a.users[username] = u
+	}
+	u.Role = role
+	u.Groups = groups
+	go a.saveUsers()
+
+	c := *u
+	return &c, nil
+}
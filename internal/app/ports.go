@@ -0,0 +1,97 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"phpservermanager/internal/server"
+)
+
+// AutoPort can be given instead of a port number to have a free port
+// allocated from the configured range
+const AutoPort = "auto"
+
+const (
+	defaultAutoPortStart = 9000
+	defaultAutoPortEnd   = 9999
+)
+
+var (
+	// ErrServerNotFound is returned for operations on unknown server IDs
+	ErrServerNotFound = errors.New("server not found")
+	// ErrPortConflict is returned when a server's host:port is already taken
+	ErrPortConflict = errors.New("port conflict")
+)
+
+// resolvePort checks that the host and port of spec don't clash with
+// another server or the manager, allocating a port first if spec asks for
+// AutoPort. It must be called with a.mu held; id is the server being
+// configured, if it already exists.
+func (a *App) resolvePort(id string, spec *server.Server) error {
+	host := spec.Host
+	if host == "" {
+		host = "localhost"
+	}
+
+	if spec.Port == AutoPort {
+		port, err := a.allocatePort(id, host)
+		if err != nil {
+			return err
+		}
+		spec.Port = port
+		return nil
+	}
+
+	return a.checkPortConflict(id, host, spec.Port)
+}
+
+// checkPortConflict reports whether host:port is used by the manager itself
+// (as configured in config.yaml or in the saved settings) or by any server
+// other than id
+func (a *App) checkPortConflict(id, host, port string) error {
+	if (port == a.listenPort && hostsOverlap(host, a.listenHost)) || (port == a.serverPort && hostsOverlap(host, a.serverHost)) {
+		return fmt.Errorf("%w: %s is used by the manager itself", ErrPortConflict, net.JoinHostPort(host, port))
+	}
+
+	for otherID, s := range a.servers {
+		if otherID != id && s.Port == port && hostsOverlap(host, s.Host) {
+			return fmt.Errorf("%w: %s is already used by server %s (%s)", ErrPortConflict, net.JoinHostPort(host, port), s.Name, otherID)
+		}
+	}
+	return nil
+}
+
+// allocatePort returns the first port in the auto port range that no
+// server is configured with and that can currently be bound on host
+func (a *App) allocatePort(id, host string) (string, error) {
+	for p := a.portRange.Start; p <= a.portRange.End; p++ {
+		port := strconv.Itoa(p)
+		if a.checkPortConflict(id, host, port) != nil {
+			continue
+		}
+		if server.PortAvailable(host, port) != nil {
+			continue
+		}
+		return port, nil
+	}
+	return "", fmt.Errorf("%w: no free port in range %d-%d", ErrPortConflict, a.portRange.Start, a.portRange.End)
+}
+
+// hostsOverlap reports whether servers bound to the two hosts would
+// compete for the same ports
+func hostsOverlap(a, b string) bool {
+	a, b = normalizeBindHost(a), normalizeBindHost(b)
+	return a == b || a == "0.0.0.0" || b == "0.0.0.0" || a == "::" || b == "::"
+}
+
+func normalizeBindHost(host string) string {
+	switch host {
+	case "", "localhost", "127.0.0.1", "::1", "[::1]":
+		return "localhost"
+	case "[::]":
+		return "::"
+	}
+	return host
+}
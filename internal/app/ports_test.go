@@ -0,0 +1,96 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"phpservermanager/internal/config"
+	"phpservermanager/internal/server"
+)
+
+func TestHostsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "localhost", true},
+		{"127.0.0.1", "::1", true},
+		{"[::1]", "localhost", true},
+		{"0.0.0.0", "192.0.2.1", true},
+		{"192.0.2.1", "::", true},
+		{"[::]", "localhost", true},
+		{"192.0.2.1", "192.0.2.1", true},
+		{"192.0.2.1", "192.0.2.2", false},
+		{"192.0.2.1", "localhost", false},
+	}
+	for _, tt := range tests {
+		if got := hostsOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("hostsOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := hostsOverlap(tt.b, tt.a); got != tt.want {
+			t.Errorf("hostsOverlap(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPortConflict(t *testing.T) {
+	a := newTestApp(t)
+	a.listenHost, a.listenPort = "0.0.0.0", "8080"
+	a.servers["1"] = &server.Server{ID: "1", Name: "blog", Host: "localhost", Port: "8000"}
+
+	tests := []struct {
+		id, host, port string
+		conflict       bool
+	}{
+		{"", "localhost", "8080", true},
+		{"", "192.0.2.1", "8080", true},
+		{"", "127.0.0.1", "8000", true},
+		{"", "0.0.0.0", "8000", true},
+		{"", "192.0.2.1", "8000", false},
+		{"", "localhost", "8001", false},
+		// A server doesn't conflict with itself
+		{"1", "localhost", "8000", false},
+	}
+	for _, tt := range tests {
+		err := a.checkPortConflict(tt.id, tt.host, tt.port)
+		if tt.conflict != (err != nil) {
+			t.Errorf("checkPortConflict(%q, %q, %q) = %v, want conflict %v", tt.id, tt.host, tt.port, err, tt.conflict)
+		}
+		if err != nil && !errors.Is(err, ErrPortConflict) {
+			t.Errorf("checkPortConflict(%q, %q, %q) = %v, want ErrPortConflict", tt.id, tt.host, tt.port, err)
+		}
+	}
+}
+
+func TestAllocatePort(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	a := newTestApp(t)
+	a.portRange = config.PortRange{Start: port, End: port}
+
+	// The only port in the range is bound by another process
+	if _, err := a.allocatePort("", "localhost"); !errors.Is(err, ErrPortConflict) {
+		t.Errorf("port in use: allocatePort = %v, want ErrPortConflict", err)
+	}
+
+	l.Close()
+	got, err := a.allocatePort("", "localhost")
+	if err != nil || got != strconv.Itoa(port) {
+		t.Errorf("free port: allocatePort = %q, %v, want %d", got, err, port)
+	}
+
+	// Ports configured for a stopped server aren't handed out either
+	a.servers["1"] = &server.Server{ID: "1", Host: "localhost", Port: strconv.Itoa(port)}
+	if _, err := a.allocatePort("", "localhost"); !errors.Is(err, ErrPortConflict) {
+		t.Errorf("port of another server: allocatePort = %v, want ErrPortConflict", err)
+	}
+	if got, err := a.allocatePort("1", "localhost"); err != nil || got != strconv.Itoa(port) {
+		t.Errorf("port of the same server: allocatePort = %q, %v, want %d", got, err, port)
+	}
+}
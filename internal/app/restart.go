@@ -0,0 +1,124 @@
+package app
+
+import (
+	"time"
+
+	"phpservermanager/internal/server"
+)
+
+const (
+	restartBackoffBase = time.Second
+	restartBackoffMax  = time.Minute
+	defaultRetryWindow = 5 * time.Minute
+)
+
+// restartState tracks the automatic restarts of a server within its
+// current retry window
+type restartState struct {
+	attempts    int
+	windowStart time.Time
+	timer       *time.Timer
+}
+
+// exitHandler returns the callback invoked when a server's process exits
+func (a *App) exitHandler(id string) func(server.ExitStatus) {
+	return func(status server.ExitStatus) {
+		if status.Requested || status.Code == 0 {
+			a.publish(EventStopped, id, status.Reason, nil)
+		} else {
+			a.publish(EventCrashed, id, status.Reason, map[string]int{"exit_code": status.Code})
+		}
+		go a.saveConfig()
+		a.handleExit(id, status)
+	}
+}
+
+// handleExit is called whenever a server process terminates and schedules
+// a restart with exponential backoff if the server's restart policy asks
+// for one.
+func (a *App) handleExit(id string, status server.ExitStatus) {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists || s.Running || !s.ShouldRestart(status) {
+		a.mu.Unlock()
+		return
+	}
+
+	window := defaultRetryWindow
+	if s.RetryWindow > 0 {
+		window = time.Duration(s.RetryWindow) * time.Second
+	}
+
+	now := time.Now()
+	st, ok := a.restarts[id]
+	if !ok || now.Sub(st.windowStart) > window {
+		st = &restartState{windowStart: now}
+		a.restarts[id] = st
+	}
+
+	if s.MaxRetries > 0 && st.attempts >= s.MaxRetries {
+		attempts := st.attempts
+		s.LastExitReason += "; restart limit reached"
+		a.mu.Unlock()
+		a.logger(id).System("giving up after %d restarts within %s", attempts, window)
+		go a.saveConfig()
+		return
+	}
+
+	st.attempts++
+	attempt := st.attempts
+	delay := restartBackoff(attempt)
+	st.timer = time.AfterFunc(delay, func() {
+		a.restartServer(id, st)
+	})
+	a.mu.Unlock()
+
+	a.logger(id).System("restarting in %s (attempt %d)", delay, attempt)
+}
+
+// restartServer performs a restart scheduled by handleExit unless it has
+// been cancelled in the meantime
+func (a *App) restartServer(id string, st *restartState) {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists || s.Running || a.restarts[id] != st {
+		a.mu.Unlock()
+		return
+	}
+	st.timer = nil
+	s.RestartCount++
+	a.mu.Unlock()
+
+	go a.saveConfig()
+
+	if !a.startServer(id) {
+		a.handleExit(id, server.ExitStatus{Code: -1, Reason: "failed to start"})
+	}
+}
+
+// resetRestarts cancels any pending restart of a server and clears its
+// retry window
+func (a *App) resetRestarts(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if st, ok := a.restarts[id]; ok {
+		if st.timer != nil {
+			st.timer.Stop()
+		}
+		delete(a.restarts, id)
+	}
+}
+
+// restartBackoff returns the delay before the given restart attempt,
+// doubling from restartBackoffBase up to restartBackoffMax
+func restartBackoff(attempt int) time.Duration {
+	delay := restartBackoffBase
+	for i := 1; i < attempt && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > restartBackoffMax {
+		delay = restartBackoffMax
+	}
+	return delay
+}
@@ -0,0 +1,221 @@
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"phpservermanager/internal/server"
+)
+
+const (
+	// SecretMask replaces secret values in API responses. Sending it back
+	// in an update keeps the stored value.
+	SecretMask = "********"
+
+	secretKeyFile = "secret.key"
+	secretPrefix  = "enc:"
+)
+
+// secretCipher returns the AES-GCM cipher used to encrypt secrets at rest.
+// The key is read from secret.key next to the servers config file and
+// generated on first use. It is never generated once encrypted values have
+// been loaded, since they could then no longer be decrypted with the
+// original key restored.
+func (a *App) secretCipher() (cipher.AEAD, error) {
+	a.secretOnce.Do(func() {
+		a.secretAEAD, a.secretErr = loadSecretCipher(filepath.Join(filepath.Dir(a.serversConfigPath), secretKeyFile), !a.secretsOnDisk)
+	})
+	return a.secretAEAD, a.secretErr
+}
+
+func loadSecretCipher(path string, create bool) (cipher.AEAD, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !create {
+		return nil, fmt.Errorf("secret key %s is missing but encrypted values exist; restore it to decrypt them", path)
+	} else if os.IsNotExist(err) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		data = []byte(hex.EncodeToString(key))
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write secret key: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read secret key: %w", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid secret key in %s", path)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret encrypts a secret value for storage
+func (a *App) encryptSecret(plain string) (string, error) {
+	aead, err := a.secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts a stored secret value. Values without the
+// encryption prefix are returned as is, so plain text secrets written by
+// hand are encrypted on the next save.
+func (a *App) decryptSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return value, nil
+	}
+
+	aead, err := a.secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted secret")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// encryptServerSecrets returns copies of the servers with their secrets
+// encrypted, ready to be written to disk. Secrets that couldn't be
+// decrypted are written back as they were loaded, unless replaced since.
+func (a *App) encryptServerSecrets(servers map[string]*server.Server) (map[string]*server.Server, error) {
+	result := make(map[string]*server.Server, len(servers))
+	for id, s := range servers {
+		c := s.Clone()
+		for k, v := range c.Secrets {
+			enc, err := a.encryptSecret(v)
+			if err != nil {
+				return nil, err
+			}
+			c.Secrets[k] = enc
+		}
+		for k, v := range a.unavailableSecrets[id] {
+			if _, replaced := c.Secrets[k]; !replaced {
+				if c.Secrets == nil {
+					c.Secrets = make(map[string]string)
+				}
+				c.Secrets[k] = v
+			}
+		}
+		result[id] = c
+	}
+	return result, nil
+}
+
+// decryptServerSecrets decrypts the secrets of servers loaded from disk in
+// place. Secrets that can't be decrypted, e.g. because secret.key was lost
+// or replaced, are kept encrypted in a.unavailableSecrets rather than being
+// passed to the server, so that restoring the key brings them back.
+func (a *App) decryptServerSecrets(servers map[string]*server.Server) {
+	for _, s := range servers {
+		for _, v := range s.Secrets {
+			if strings.HasPrefix(v, secretPrefix) {
+				a.secretsOnDisk = true
+			}
+		}
+	}
+
+	for _, s := range servers {
+		for k, v := range s.Secrets {
+			plain, err := a.decryptSecret(v)
+			if err != nil {
+				fmt.Printf("Secret %s of server %s is unavailable and kept encrypted: %v\n", k, s.ID, err)
+				if a.unavailableSecrets[s.ID] == nil {
+					a.unavailableSecrets[s.ID] = make(map[string]string)
+				}
+				a.unavailableSecrets[s.ID][k] = v
+				delete(s.Secrets, k)
+				continue
+			}
+			s.Secrets[k] = plain
+		}
+	}
+}
+
+// maskSecrets hides the secret values of a server copy
+func maskSecrets(s *server.Server) *server.Server {
+	for k := range s.Secrets {
+		s.Secrets[k] = SecretMask
+	}
+	return s
+}
+
+// maskServerSecrets hides the secret values of a copy of server id,
+// listing the secrets that couldn't be decrypted as well. It must be called
+// with a.mu held.
+func (a *App) maskServerSecrets(id string, s *server.Server) *server.Server {
+	for k := range a.unavailableSecrets[id] {
+		if s.Secrets == nil {
+			s.Secrets = make(map[string]string)
+		}
+		s.Secrets[k] = SecretMask
+	}
+	return maskSecrets(s)
+}
+
+// mergeSecrets returns the secrets of an update, replacing masked values
+// with the ones currently stored. A nil update keeps the current secrets,
+// while an empty one removes them all.
+func mergeSecrets(current, update map[string]string) map[string]string {
+	if update == nil {
+		return current
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(update))
+	for k, v := range update {
+		if v == SecretMask {
+			old, ok := current[k]
+			if !ok {
+				continue
+			}
+			v = old
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// updateUnavailableSecrets forgets the secrets of server id that couldn't be
+// decrypted and were removed or replaced by an update. Only those sent back
+// masked are kept. It must be called with a.mu held.
+func (a *App) updateUnavailableSecrets(id string, update map[string]string) {
+	if update == nil {
+		return
+	}
+	for k := range a.unavailableSecrets[id] {
+		if update[k] != SecretMask {
+			delete(a.unavailableSecrets[id], k)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"phpservermanager/internal/config"
+	"phpservermanager/internal/server"
+)
+
+func TestMergeSecrets(t *testing.T) {
+	current := map[string]string{"A": "1", "B": "2"}
+	tests := []struct {
+		name   string
+		update map[string]string
+		want   map[string]string
+	}{
+		{"omitted", nil, current},
+		{"cleared", map[string]string{}, nil},
+		{"masked values kept", map[string]string{"A": SecretMask, "B": SecretMask}, current},
+		{"one removed", map[string]string{"A": SecretMask}, map[string]string{"A": "1"}},
+		{"one changed", map[string]string{"A": SecretMask, "B": "3"}, map[string]string{"A": "1", "B": "3"}},
+		{"unknown masked value dropped", map[string]string{"C": SecretMask}, map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		if got := mergeSecrets(current, tt.update); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// reopen loads the files of a into a new app, as on a manager restart
+func reopen(a *App) *App {
+	b := NewApp(&config.Config{ServersConfigPath: a.serversConfigPath})
+	b.loadConfig()
+	return b
+}
+
+func TestSecretsAtRest(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	id, err := a.CreateServer(ctx, &server.Server{Name: "site", Port: "9001", Directory: "/srv", Secrets: map[string]string{"DB_PASSWORD": "hunter2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.saveConfig()
+
+	data, err := os.ReadFile(a.serversConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") || !strings.Contains(string(data), secretPrefix) {
+		t.Fatalf("secret not encrypted on disk: %s", data)
+	}
+	if s, _ := a.GetServer(id); s.Secrets["DB_PASSWORD"] != SecretMask {
+		t.Errorf("secret not masked: %q", s.Secrets["DB_PASSWORD"])
+	}
+
+	b := reopen(a)
+	if got := b.servers[id].Secrets["DB_PASSWORD"]; got != "hunter2" {
+		t.Fatalf("decrypted %q", got)
+	}
+
+	// Omitting secrets from an update keeps them
+	spec := b.servers[id].Clone()
+	spec.Secrets = nil
+	if err := b.UpdateServer(ctx, id, spec); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.servers[id].Secrets["DB_PASSWORD"]; got != "hunter2" {
+		t.Errorf("update without secrets left %q", got)
+	}
+
+	// Without the key the secret is kept encrypted, and no new key is made
+	keyPath := filepath.Join(filepath.Dir(a.serversConfigPath), secretKeyFile)
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(keyPath)
+	c := reopen(a)
+	if _, ok := c.servers[id].Secrets["DB_PASSWORD"]; ok {
+		t.Error("undecryptable secret passed to the server")
+	}
+	if s, _ := c.GetServer(id); s.Secrets["DB_PASSWORD"] != SecretMask {
+		t.Error("undecryptable secret not listed")
+	}
+	c.saveConfig()
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Fatal("a new secret key replaced the lost one")
+	}
+
+	// Restoring the key brings the secret back
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := reopen(a).servers[id].Secrets["DB_PASSWORD"]; got != "hunter2" {
+		t.Errorf("secret after restoring the key: %q", got)
+	}
+
+	// Removing an undecryptable secret is respected. The apps above may
+	// still be saving in the background, so this uses a copy of the files.
+	copied := newTestApp(t)
+	data, err = os.ReadFile(a.serversConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(copied.serversConfigPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := reopen(copied)
+	spec = d.servers[id].Clone()
+	spec.Secrets = map[string]string{}
+	if err := d.UpdateServer(ctx, id, spec); err != nil {
+		t.Fatal(err)
+	}
+	d.saveConfig()
+	os.WriteFile(filepath.Join(filepath.Dir(copied.serversConfigPath), secretKeyFile), key, 0600)
+	if _, ok := reopen(copied).servers[id].Secrets["DB_PASSWORD"]; ok {
+		t.Error("removed secret came back")
+	}
+}
+
+func TestLoadSecretCipher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, secretKeyFile)
+
+	if _, err := loadSecretCipher(path, false); err == nil {
+		t.Error("missing key accepted without creating one")
+	}
+	first, err := loadSecretCipher(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("key file: %v", err)
+	}
+	second, err := loadSecretCipher(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, first.NonceSize())
+	sealed := first.Seal(nil, nonce, []byte("value"), nil)
+	if plain, err := second.Open(nil, nonce, sealed, nil); err != nil || string(plain) != "value" {
+		t.Errorf("reloaded key doesn't decrypt: %v", err)
+	}
+
+	for _, bad := range []string{"", "zz", strings.Repeat("ab", 16)} {
+		os.WriteFile(path, []byte(bad), 0600)
+		if _, err := loadSecretCipher(path, true); err == nil {
+			t.Errorf("invalid key %q accepted", bad)
+		}
+	}
+}
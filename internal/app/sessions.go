@@ -0,0 +1,104 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"phpservermanager/internal/auth"
+)
+
+const (
+	defaultSessionIdleTimeout     = 30 * time.Minute
+	defaultSessionAbsoluteTimeout = 12 * time.Hour
+)
+
+// session is a login of the web UI. Sessions are kept in memory only, so
+// restarting the manager logs everybody out.
+type session struct {
+	username  string
+	csrfToken string
+	created   time.Time
+	lastSeen  time.Time
+}
+
+// sessionExpired reports whether a session timed out at now
+func (a *App) sessionExpired(s *session, now time.Time) bool {
+	return now.Sub(s.lastSeen) >= a.sessionIdle || now.Sub(s.created) >= a.sessionAbsolute
+}
+
+// CreateSession starts a session for a user, returning the session ID for
+// the cookie and the CSRF token state-changing requests must send
+func (a *App) CreateSession(username string) (string, string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for sid, s := range a.sessions {
+		if a.sessionExpired(s, now) {
+			delete(a.sessions, sid)
+		}
+	}
+	a.sessions[id] = &session{username: username, csrfToken: csrfToken, created: now, lastSeen: now}
+	return id, csrfToken, nil
+}
+
+// AuthenticateSession looks up a session, extending its idle timeout, and
+// returns its user and CSRF token
+func (a *App) AuthenticateSession(id string) (*auth.User, string, bool) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, exists := a.sessions[id]
+	if !exists {
+		return nil, "", false
+	}
+	if a.sessionExpired(s, now) {
+		delete(a.sessions, id)
+		return nil, "", false
+	}
+	u, exists := a.users[s.username]
+	if !exists {
+		delete(a.sessions, id)
+		return nil, "", false
+	}
+
+	s.lastSeen = now
+	c := *u
+	return &c, s.csrfToken, true
+}
+
+// DeleteSession ends a session
+func (a *App) DeleteSession(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, id)
+}
+
+// deleteUserSessions ends every session of a user but except. It must be
+// called with a.mu held.
+func (a *App) deleteUserSessions(username, except string) {
+	for id, s := range a.sessions {
+		if s.username == username && id != except {
+			delete(a.sessions, id)
+		}
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
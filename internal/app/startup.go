@@ -0,0 +1,124 @@
+package app
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"phpservermanager/internal/server"
+)
+
+// recoverProcesses looks for server processes left running by a previous
+// manager instance and adopts, kills or forgets them according to the
+// configured orphan policy. Recorded processes that are gone or can't be
+// verified as ours are forgotten.
+func (a *App) recoverProcesses() {
+	a.mu.Lock()
+	var candidates []*server.Server
+	for _, s := range a.servers {
+		if s.Pid != 0 {
+			candidates = append(candidates, s)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, s := range candidates {
+		logger := a.logger(s.ID)
+		if !server.FindOrphan(s) {
+			a.mu.Lock()
+			s.ForgetProcess()
+			a.mu.Unlock()
+			continue
+		}
+
+		switch a.orphanPolicy {
+		case server.OrphanKill:
+			server.KillOrphan(s, &a.mu, logger)
+		case server.OrphanIgnore:
+			logger.System("leaving process group %d from a previous manager instance unmanaged", s.Pid)
+			a.mu.Lock()
+			s.ForgetProcess()
+			a.mu.Unlock()
+		default:
+			server.Adopt(s, a.processes, &a.mu, logger, a.exitHandler(s.ID))
+			a.startACME(s.ID)
+		}
+	}
+
+	go a.saveConfig()
+}
+
+// restoreServers starts every server that was running when the manager
+// last stopped, or that is marked to start automatically. Servers are
+// started in ascending start_order (then by ID), each after waiting its
+// start_delay.
+func (a *App) restoreServers() {
+	type startup struct {
+		id    string
+		order int
+		delay time.Duration
+	}
+
+	a.mu.Lock()
+	var pending []startup
+	for _, s := range a.servers {
+		if !s.Running && (s.Autostart || s.DesiredState == server.StateRunning) {
+			pending = append(pending, startup{
+				id:    s.ID,
+				order: s.StartOrder,
+				delay: time.Duration(s.StartDelay) * time.Second,
+			})
+		}
+	}
+	a.mu.Unlock()
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].order != pending[j].order {
+			return pending[i].order < pending[j].order
+		}
+		return serverIDLess(pending[i].id, pending[j].id)
+	})
+
+	for _, s := range pending {
+		if s.delay > 0 {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-time.After(s.delay):
+			}
+		}
+		if a.ctx.Err() != nil {
+			return
+		}
+
+		a.logger(s.id).System("restoring server after manager start")
+		if !a.startServer(s.id) {
+			a.logger(s.id).System("failed to restore server")
+		}
+	}
+}
+
+// setDesiredState records whether a server should be running and persists it
+func (a *App) setDesiredState(id, state string) {
+	a.mu.Lock()
+	s, exists := a.servers[id]
+	if !exists || s.DesiredState == state {
+		a.mu.Unlock()
+		return
+	}
+	s.DesiredState = state
+	a.mu.Unlock()
+
+	go a.saveConfig()
+}
+
+// serverIDLess orders numeric server IDs numerically and anything else
+// lexically
+func serverIDLess(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
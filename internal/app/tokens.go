@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"phpservermanager/internal/auth"
+)
+
+const (
+	tokensFile = "tokens.json"
+	// tokenUseResolution is how often the last use of a token is persisted
+	tokenUseResolution = time.Minute
+)
+
+// ErrTokenNotFound is returned for operations on unknown token IDs
+var ErrTokenNotFound = errors.New("token not found")
+
+func (a *App) tokensPath() string {
+	return filepath.Join(filepath.Dir(a.serversConfigPath), tokensFile)
+}
+
+// loadTokens reads the API tokens from disk
+func (a *App) loadTokens() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data, err := os.ReadFile(a.tokensPath())
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error reading tokens: %v\n", err)
+		return
+	}
+
+	var tokens []*auth.Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		fmt.Printf("Error parsing tokens: %v\n", err)
+		return
+	}
+	for _, t := range tokens {
+		a.tokens[t.ID] = t
+	}
+}
+
+// saveTokens writes the API tokens to disk
+func (a *App) saveTokens() {
+	a.mu.Lock()
+	tokens := make([]*auth.Token, 0, len(a.tokens))
+	for _, t := range a.tokens {
+		c := *t
+		tokens = append(tokens, &c)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		fmt.Printf("Error serializing tokens: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(a.tokensPath(), data, 0600); err != nil {
+		fmt.Printf("Error saving tokens: %v\n", err)
+	}
+}
+
+// AuthenticateToken checks an API token, returning its owner limited to
+// the token's scopes
+func (a *App) AuthenticateToken(token string) (*auth.User, bool) {
+	id, ok := auth.TokenID(token)
+	if !ok {
+		return nil, false
+	}
+	hash := auth.HashToken(token)
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, exists := a.tokens[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) != 1 || t.Expired(now) {
+		return nil, false
+	}
+	owner, exists := a.users[t.Owner]
+	if !exists {
+		return nil, false
+	}
+
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= tokenUseResolution {
+		t.LastUsedAt = &now
+		go a.saveTokens()
+	}
+
+	u := *owner
+	u.Scopes = append([]string{}, t.Scopes...)
+	return &u, true
+}
+
+// CreateToken creates an API token for owner and returns the token string,
+// which is not stored and can't be retrieved later
+func (a *App) CreateToken(ctx context.Context, owner, name string, scopes []string, expiresAt *time.Time) (string, auth.Token, error) {
+	id, token, err := auth.NewToken()
+	if err != nil {
+		return "", auth.Token{}, err
+	}
+
+	t := &auth.Token{
+		ID:        id,
+		Name:      name,
+		Owner:     owner,
+		Scopes:    scopes,
+		Hash:      auth.HashToken(token),
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	a.mu.Lock()
+	if _, exists := a.users[owner]; !exists {
+		a.mu.Unlock()
+		return "", auth.Token{}, ErrUserNotFound
+	}
+	a.tokens[id] = t
+	a.mu.Unlock()
+
+	go a.saveTokens()
+	c := *t
+	c.Hash = ""
+	a.audit(ctx, AuditTokenCreate, "", id, auditDiff(nil, &c), nil)
+	return token, c, nil
+}
+
+// GetTokens returns the tokens of owner, or every token if owner is empty,
+// without their hashes
+func (a *App) GetTokens(owner string) []auth.Token {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tokens := []auth.Token{}
+	for _, t := range a.tokens {
+		if owner != "" && t.Owner != owner {
+			continue
+		}
+		c := *t
+		c.Hash = ""
+		tokens = append(tokens, c)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// DeleteToken revokes a token. Unless owner is empty, only tokens of owner
+// can be revoked.
+func (a *App) DeleteToken(ctx context.Context, id, owner string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, exists := a.tokens[id]
+	if !exists || (owner != "" && t.Owner != owner) {
+		return ErrTokenNotFound
+	}
+	delete(a.tokens, id)
+	a.audit(ctx, AuditTokenDelete, "", id, nil, nil)
+	go a.saveTokens()
+	return nil
+}
+
+// deleteUserTokens revokes every token of a user. It must be called with
+// a.mu held.
+func (a *App) deleteUserTokens(username string) {
+	for id, t := range a.tokens {
+		if t.Owner == username {
+			delete(a.tokens, id)
+		}
+	}
+	go a.saveTokens()
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"phpservermanager/internal/auth"
+)
+
+var (
+	// ErrInvalidCode is returned when a second factor code doesn't match
+	ErrInvalidCode = errors.New("invalid code")
+	// ErrNoEnrollment is returned when confirming TOTP without enrolling
+	ErrNoEnrollment = errors.New("no TOTP enrollment in progress")
+)
+
+// BeginTOTPEnrollment generates a new TOTP secret for a user. It only takes
+// effect once confirmed with a code from the authenticator app.
+func (a *App) BeginTOTPEnrollment(username string) (string, string, error) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	encrypted, err := a.encryptSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if !exists {
+		return "", "", ErrUserNotFound
+	}
+	u.TOTPPendingSecret = encrypted
+	go a.saveUsers()
+	return secret, auth.TOTPURI(username, secret), nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP for a user whose code matches the
+// pending secret, returning fresh recovery codes
+func (a *App) ConfirmTOTPEnrollment(ctx context.Context, username, code string) ([]string, error) {
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	if u.TOTPPendingSecret == "" {
+		return nil, ErrNoEnrollment
+	}
+	secret, err := a.decryptSecret(u.TOTPPendingSecret)
+	if err != nil {
+		return nil, err
+	}
+	counter, ok := auth.VerifyTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	u.TOTPEnabled = true
+	u.TOTPSecret = u.TOTPPendingSecret
+	u.TOTPPendingSecret = ""
+	u.TOTPLastCounter = counter
+	u.RecoveryCodes = hashes
+	a.audit(ctx, AuditTOTPEnable, "", username, nil, nil)
+	go a.saveUsers()
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code or a recovery code of a user.
+// Recovery codes can only be used once.
+func (a *App) VerifySecondFactor(username, code string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if !exists || !u.TOTPEnabled {
+		return false
+	}
+
+	if secret, err := a.decryptSecret(u.TOTPSecret); err == nil {
+		if counter, ok := auth.VerifyTOTP(secret, code, time.Now(), u.TOTPLastCounter); ok {
+			u.TOTPLastCounter = counter
+			go a.saveUsers()
+			return true
+		}
+	}
+
+	hash := auth.HashRecoveryCode(code)
+	for i, stored := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			go a.saveUsers()
+			return true
+		}
+	}
+	return false
+}
+
+// DisableTOTP removes the second factor of a user, e.g. when an admin
+// resets it for a user who lost their device
+func (a *App) DisableTOTP(ctx context.Context, username string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+	u.TOTPPendingSecret = ""
+	u.TOTPLastCounter = 0
+	u.RecoveryCodes = nil
+	a.audit(ctx, AuditTOTPDisable, "", username, nil, nil)
+	go a.saveUsers()
+	return nil
+}
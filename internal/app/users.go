@@ -0,0 +1,349 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"phpservermanager/internal/auth"
+)
+
+const usersFile = "users.json"
+
+var (
+	// ErrUserNotFound is returned for operations on unknown usernames
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when creating a user whose name is taken
+	ErrUserExists = errors.New("user already exists")
+	// ErrLastAdmin is returned when a change would leave no admin
+	ErrLastAdmin = errors.New("at least one admin is required")
+)
+
+func (a *App) usersPath() string {
+	return filepath.Join(filepath.Dir(a.serversConfigPath), usersFile)
+}
+
+// loadUsers reads the user store. On first start it is seeded with the
+// single user from config.yaml, who becomes an admin.
+func (a *App) loadUsers() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data, err := os.ReadFile(a.usersPath())
+	if os.IsNotExist(err) {
+		if a.auth.Username != "" && a.auth.PasswordHash != "" {
+			a.users[a.auth.Username] = &auth.User{
+				Username:     a.auth.Username,
+				PasswordHash: a.auth.PasswordHash,
+				Role:         auth.RoleAdmin,
+				CreatedAt:    time.Now(),
+			}
+			go a.saveUsers()
+		}
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error reading users: %v\n", err)
+		return
+	}
+
+	var users []*auth.User
+	if err := json.Unmarshal(data, &users); err != nil {
+		fmt.Printf("Error parsing users: %v\n", err)
+		return
+	}
+	for _, u := range users {
+		a.users[u.Username] = u
+		if u.TOTPSecret != "" || u.TOTPPendingSecret != "" {
+			a.secretsOnDisk = true
+		}
+	}
+}
+
+// saveUsers writes the user store to disk
+func (a *App) saveUsers() {
+	a.mu.Lock()
+	users := make([]*auth.User, 0, len(a.users))
+	for _, u := range a.users {
+		c := *u
+		users = append(users, &c)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		fmt.Printf("Error serializing users: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(a.usersPath(), data, 0600); err != nil {
+		fmt.Printf("Error saving users: %v\n", err)
+	}
+}
+
+// AuthRequired reports whether any users exist. Without users the API is
+// open, as it was without credentials in config.yaml.
+func (a *App) AuthRequired() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.users) > 0
+}
+
+// Authenticate checks a username and password, returning a copy of the
+// user on success
+func (a *App) Authenticate(username, password string) (*auth.User, bool) {
+	a.mu.Lock()
+	u, exists := a.users[username]
+	var c auth.User
+	if exists {
+		c = *u
+	}
+	a.mu.Unlock()
+
+	// bcrypt is slow, so compare outside of the lock. Unknown users and
+	// users without a password are compared against a dummy hash, so that
+	// the time taken doesn't tell which usernames exist.
+	if !exists || c.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, false
+	}
+	if !c.CheckPassword(password) {
+		return nil, false
+	}
+	return &c, true
+}
+
+// dummyPasswordHash is compared against when authenticating unknown users
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// GetUsers returns all users without their credentials
+func (a *App) GetUsers() []auth.User {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	users := make([]auth.User, 0, len(a.users))
+	for _, u := range a.users {
+		users = append(users, u.Public())
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// GetUser returns a user without their credentials
+func (a *App) GetUser(username string) (auth.User, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if !exists {
+		return auth.User{}, false
+	}
+	return u.Public(), true
+}
+
+// CreateUser adds a user with the given password, role and groups
+func (a *App) CreateUser(ctx context.Context, username, password, role string, groups []string) error {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, exists := a.users[username]; exists {
+		return ErrUserExists
+	}
+	u := &auth.User{Username: username, PasswordHash: hash, Role: role, Groups: groups, CreatedAt: time.Now()}
+	a.users[username] = u
+	a.audit(ctx, AuditUserCreate, "", username, auditUserDiff(nil, u), nil)
+	go a.saveUsers()
+	return nil
+}
+
+// UpdateUser changes the password, role and groups of a user. Empty
+// values and nil groups are left unchanged. A new password or role ends
+// the user's sessions and revokes their API tokens.
+func (a *App) UpdateUser(ctx context.Context, username, password, role string, groups []string) error {
+	var hash string
+	if password != "" {
+		var err error
+		if hash, err = auth.HashPassword(password); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	if role != "" && role != auth.RoleAdmin && u.Role == auth.RoleAdmin && a.adminCount() == 1 {
+		return ErrLastAdmin
+	}
+
+	before := *u
+	if hash != "" {
+		u.PasswordHash = hash
+	}
+	if role != "" {
+		u.Role = role
+	}
+	if groups != nil {
+		u.Groups = groups
+	}
+	if u.PasswordHash != before.PasswordHash || u.Role != before.Role {
+		a.deleteUserSessions(username, "")
+		a.deleteUserTokens(username)
+	}
+	a.audit(ctx, AuditUserUpdate, "", username, auditUserDiff(&before, u), nil)
+	go a.saveUsers()
+	return nil
+}
+
+// RenameUser changes the username and password of an existing user, as
+// done by users updating their own credentials. Their other sessions end,
+// keeping only the session currentSession, if any.
+func (a *App) RenameUser(ctx context.Context, username, newUsername, password, currentSession string) error {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	before := *u
+	if newUsername != username {
+		if _, taken := a.users[newUsername]; taken {
+			return ErrUserExists
+		}
+		delete(a.users, username)
+		u.Username = newUsername
+		a.users[newUsername] = u
+		a.renameUserReferences(username, newUsername)
+	}
+	u.PasswordHash = hash
+	a.deleteUserSessions(newUsername, currentSession)
+	a.audit(ctx, AuditAuthUpdate, "", username, auditUserDiff(&before, u), nil)
+	go a.saveUsers()
+	return nil
+}
+
+// DeleteUser removes a user. The last admin can't be deleted.
+func (a *App) DeleteUser(ctx context.Context, username string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, exists := a.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	if u.Role == auth.RoleAdmin && a.adminCount() == 1 {
+		return ErrLastAdmin
+	}
+	delete(a.users, username)
+	a.audit(ctx, AuditUserDelete, "", username, auditUserDiff(u, nil), nil)
+	a.deleteUserTokens(username)
+	a.deleteUserSessions(username, "")
+	a.removeUserReferences(username)
+	go a.saveUsers()
+	return nil
+}
+
+// renameUserReferences points server owners, ACL entries, tokens and
+// sessions of a renamed user at the new name. It must be called with a.mu
+// held.
+func (a *App) renameUserReferences(oldName, newName string) {
+	for _, s := range a.servers {
+		if s.Owner == oldName {
+			s.Owner = newName
+		}
+		for i := range s.ACL {
+			if s.ACL[i].User == oldName {
+				s.ACL[i].User = newName
+			}
+		}
+	}
+	for _, t := range a.tokens {
+		if t.Owner == oldName {
+			t.Owner = newName
+		}
+	}
+	for _, s := range a.sessions {
+		if s.username == oldName {
+			s.username = newName
+		}
+	}
+	go a.saveConfig()
+	go a.saveTokens()
+}
+
+// removeUserReferences clears the server ownerships and ACL entries of a
+// deleted user, so that a new user with the same name doesn't inherit
+// them. It must be called with a.mu held.
+func (a *App) removeUserReferences(username string) {
+	for _, s := range a.servers {
+		if s.Owner == username {
+			s.Owner = ""
+		}
+		acl := s.ACL[:0]
+		for _, e := range s.ACL {
+			if e.User != username {
+				acl = append(acl, e)
+			}
+		}
+		s.ACL = acl
+	}
+	go a.saveConfig()
+}
+
+// auditUserDiff describes the changes to a user without their credentials.
+// A changed password shows as masked values.
+func auditUserDiff(before, after *auth.User) map[string]AuditChange {
+	var b, a *auth.User
+	if before != nil {
+		p := before.Public()
+		b = &p
+	}
+	if after != nil {
+		p := after.Public()
+		a = &p
+	}
+	changes := auditDiff(b, a)
+	if before != nil && after != nil && before.PasswordHash != after.PasswordHash {
+		if changes == nil {
+			changes = make(map[string]AuditChange)
+		}
+		changes["password"] = AuditChange{Before: SecretMask, After: SecretMask}
+	}
+	return changes
+}
+
+// adminCount returns the number of admins. It must be called with a.mu held.
+func (a *App) adminCount() int {
+	n := 0
+	for _, u := range a.users {
+		if u.Role == auth.RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
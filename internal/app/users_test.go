@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/server"
+)
+
+func TestUserChangesEndLogins(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	for _, name := range []string{"admin", "alice"} {
+		if err := a.CreateUser(ctx, name, "password", auth.RoleAdmin, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login := func() (string, string) {
+		sid, _, err := a.CreateSession("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _, err := a.CreateToken(ctx, "alice", "ci", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sid, token
+	}
+	valid := func(sid, token string) (bool, bool) {
+		_, _, sessionOK := a.AuthenticateSession(sid)
+		_, tokenOK := a.AuthenticateToken(token)
+		return sessionOK, tokenOK
+	}
+
+	sid, token := login()
+	if err := a.UpdateUser(ctx, "alice", "", "", []string{"ops"}); err != nil {
+		t.Fatal(err)
+	}
+	if s, tk := valid(sid, token); !s || !tk {
+		t.Error("changing groups ended the logins")
+	}
+
+	if err := a.UpdateUser(ctx, "alice", "", auth.RoleViewer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s, tk := valid(sid, token); s || tk {
+		t.Errorf("demotion kept session %v, token %v", s, tk)
+	}
+
+	sid, token = login()
+	if err := a.UpdateUser(ctx, "alice", "new password", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s, tk := valid(sid, token); s || tk {
+		t.Errorf("password reset kept session %v, token %v", s, tk)
+	}
+
+	// Changing your own password keeps the current session and tokens
+	current, token := login()
+	other, _ := login()
+	if err := a.RenameUser(ctx, "alice", "alice", "another password", current); err != nil {
+		t.Fatal(err)
+	}
+	if s, tk := valid(current, token); !s || !tk {
+		t.Errorf("own password change ended the current session (%v) or token (%v)", s, tk)
+	}
+	if s, _ := valid(other, token); s {
+		t.Error("own password change kept another session")
+	}
+}
+
+func TestDeleteUserClearsReferences(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	for _, name := range []string{"admin", "alice"} {
+		if err := a.CreateUser(ctx, name, "password", auth.RoleAdmin, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, err := a.CreateServer(ctx, &server.Server{
+		Name: "site", Port: "9001", Directory: "/srv", Owner: "alice",
+		ACL: []auth.ACLEntry{
+			{User: "alice", Permissions: []string{auth.PermViewServers}},
+			{Group: "alice", Permissions: []string{auth.PermViewServers}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.DeleteUser(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := a.GetServer(id)
+	if s.Owner != "" || len(s.ACL) != 1 || s.ACL[0].Group != "alice" {
+		t.Errorf("deleted user is still referenced: owner %q, ACL %+v", s.Owner, s.ACL)
+	}
+
+	// A new account with the same name starts without access
+	if err := a.CreateUser(ctx, "alice", "password", auth.RoleMember, nil); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := a.Authenticate("alice", "password")
+	if s, _ := a.GetServer(id); s.Allows(u, auth.PermViewServers) {
+		t.Error("new user inherited the access of the deleted one")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newTestApp(t)
+	if err := a.CreateUser(context.Background(), "alice", "password", auth.RoleViewer, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"alice", "password", true},
+		{"alice", "wrong", false},
+		{"alice", "", false},
+		{"bob", "password", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if _, ok := a.Authenticate(tt.username, tt.password); ok != tt.ok {
+			t.Errorf("Authenticate(%q, %q) = %v, want %v", tt.username, tt.password, ok, tt.ok)
+		}
+	}
+}
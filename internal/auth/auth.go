@@ -0,0 +1,264 @@
+// Package auth defines manager users, their roles and the permissions each
+// role grants.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+	// RoleMember grants nothing by itself; members only get access to the
+	// servers they own or are listed on
+	RoleMember = "member"
+)
+
+// Permissions checked by the API handlers
+const (
+	PermViewServers    = "servers:view"
+	PermViewLogs       = "logs:view"
+	PermControlServers = "servers:control"
+	PermManageServers  = "servers:manage"
+	PermManageSettings = "settings:manage"
+	PermManageUsers    = "users:manage"
+	PermViewAudit      = "audit:view"
+)
+
+// ServerPermissions are the permissions that can be granted on single
+// servers through ownership or an ACL
+var ServerPermissions = []string{PermViewServers, PermViewLogs, PermControlServers, PermManageServers}
+
+var rolePermissions = map[string][]string{
+	RoleMember:   {},
+	RoleViewer:   {PermViewServers, PermViewLogs},
+	RoleOperator: {PermViewServers, PermViewLogs, PermControlServers},
+	RoleAdmin:    {PermViewServers, PermViewLogs, PermControlServers, PermManageServers, PermManageSettings, PermManageUsers, PermViewAudit},
+}
+
+// ProviderOIDC marks users signed up through OpenID Connect
+const ProviderOIDC = "oidc"
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// User is an account that can log in to the manager
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Role         string    `json:"role"`
+	Groups       []string  `json:"groups,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Provider is ProviderOIDC for users signed up through single sign-on,
+	// whose role and groups come from the identity provider
+	Provider string `json:"provider,omitempty"`
+
+	// TOTPEnabled requires a second factor at login. The secrets are
+	// stored encrypted and recovery codes as hashes.
+	TOTPEnabled       bool     `json:"totp_enabled"`
+	TOTPSecret        string   `json:"totp_secret,omitempty"`
+	TOTPPendingSecret string   `json:"totp_pending_secret,omitempty"`
+	TOTPLastCounter   int64    `json:"totp_last_counter,omitempty"`
+	RecoveryCodes     []string `json:"recovery_codes,omitempty"`
+
+	// Scopes limits the permissions of a user authenticated with an API
+	// token; nil means no limit
+	Scopes []string `json:"-"`
+}
+
+// ACLEntry grants permissions on a server to a user or to every member of
+// a group
+type ACLEntry struct {
+	User        string   `json:"user,omitempty"`
+	Group       string   `json:"group,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// Validate checks that the entry names exactly one user or group and only
+// grants server permissions
+func (e ACLEntry) Validate() error {
+	if (e.User == "") == (e.Group == "") {
+		return errors.New("ACL entries need either a user or a group")
+	}
+	for _, p := range e.Permissions {
+		if !ValidServerPermission(p) {
+			return fmt.Errorf("unknown server permission %q", p)
+		}
+	}
+	return nil
+}
+
+// ValidServerPermission reports whether perm can be granted on a server
+func ValidServerPermission(perm string) bool {
+	for _, p := range ServerPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// ValidUsername reports whether name can be used as a username
+func ValidUsername(name string) bool {
+	return usernamePattern.MatchString(name)
+}
+
+// Permissions returns the permissions granted by a role
+func Permissions(role string) []string {
+	return append([]string(nil), rolePermissions[role]...)
+}
+
+// Can reports whether the user has a permission
+func (u *User) Can(perm string) bool {
+	if !u.inScope(perm) {
+		return false
+	}
+	for _, p := range rolePermissions[u.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// CanOn reports whether the user has perm on a server with the given owner
+// and ACL. Global role permissions apply to every server, owners have every
+// server permission, and any ACL grant also lets the user see the server.
+func (u *User) CanOn(perm, owner string, acl []ACLEntry) bool {
+	if !u.inScope(perm) {
+		return false
+	}
+	if u.Can(perm) {
+		return true
+	}
+	if owner != "" && owner == u.Username {
+		return ValidServerPermission(perm)
+	}
+	for _, e := range acl {
+		if !u.matches(e) {
+			continue
+		}
+		if perm == PermViewServers && len(e.Permissions) > 0 {
+			return true
+		}
+		for _, p := range e.Permissions {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inScope reports whether perm is within the scopes of a token user. Any
+// server scope implies seeing servers, as acting on one requires finding it.
+func (u *User) inScope(perm string) bool {
+	if u.Scopes == nil {
+		return true
+	}
+	for _, s := range u.Scopes {
+		if s == perm || (perm == PermViewServers && ValidServerPermission(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) matches(e ACLEntry) bool {
+	if e.User != "" {
+		return e.User == u.Username
+	}
+	for _, g := range u.Groups {
+		if g == e.Group {
+			return true
+		}
+	}
+	return false
+}
+
+// Public returns a copy of the user without password hash, second factor
+// secrets and recovery codes, for API responses
+func (u *User) Public() User {
+	c := *u
+	c.PasswordHash = ""
+	c.TOTPSecret = ""
+	c.TOTPPendingSecret = ""
+	c.TOTPLastCounter = 0
+	c.RecoveryCodes = nil
+	return c
+}
+
+// CheckPassword reports whether password matches the user's hash
+func (u *User) CheckPassword(password string) bool {
+	return u.PasswordHash != "" && bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// HashPassword hashes a password for storage
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the authenticated user
+func NewContext(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// FromContext returns the authenticated user stored in ctx
+func FromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(contextKey{}).(*User)
+	return u, ok && u != nil
+}
+
+// SessionCookie is the name of the web UI session cookie
+const SessionCookie = "psm_session"
+
+// CSRFHeader carries the CSRF token of a session on state-changing requests
+const CSRFHeader = "X-CSRF-Token"
+
+type sessionKey struct{}
+
+// NewSessionContext returns a copy of ctx recording that the request was
+// authenticated by a session cookie with the given CSRF token
+func NewSessionContext(ctx context.Context, csrfToken string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, csrfToken)
+}
+
+// SessionCSRFToken returns the CSRF token of the session that
+// authenticated the request, if it was authenticated by a session
+func SessionCSRFToken(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(sessionKey{}).(string)
+	return token, ok
+}
+
+type clientIPKey struct{}
+
+// NewClientIPContext returns a copy of ctx carrying the IP address of the
+// client making the request
+func NewClientIPContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the IP address of the client stored in ctx
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// TokenPrefix starts every API token, making leaked tokens easy to spot
+const TokenPrefix = "psm_"
+
+// Token is an API token. Only a hash of the token itself is stored. A token
+// acts as its owner, limited to its scopes.
+type Token struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Scopes     []string   `json:"scopes"`
+	Hash       string     `json:"hash,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// NewToken generates a token ID and the secret token string handed to
+// the user
+func NewToken() (id, token string, err error) {
+	b := make([]byte, 8+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(b[:8])
+	return id, TokenPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(b[8:]), nil
+}
+
+// TokenID extracts the ID from a token string
+func TokenID(token string) (string, bool) {
+	rest, ok := strings.CutPrefix(token, TokenPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	return id, ok
+}
+
+// HashToken returns the stored hash of a token string
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired reports whether the token has expired at now
+func (t *Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// ValidPermission reports whether perm is a known permission, and so a
+// valid token scope
+func ValidPermission(perm string) bool {
+	for _, p := range rolePermissions[RoleAdmin] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
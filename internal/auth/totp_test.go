@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed of the RFC 6238 test vectors,
+// "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	// Secrets are accepted in lower case and with padding
+	if got, _ := TOTPCode(strings.ToLower(rfc6238Secret)+"==", 1); got != "287082" {
+		t.Errorf("lower case secret gave %s", got)
+	}
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("invalid secret was accepted")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code := func(counter int64) string {
+		c, err := TOTPCode(rfc6238Secret, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		lastCounter int64
+		want        int64
+		ok          bool
+	}{
+		{"current", code(current), 0, current, true},
+		{"surrounding spaces", " " + code(current) + " ", 0, current, true},
+		{"previous period", code(current - 1), 0, current - 1, true},
+		{"next period", code(current + 1), 0, current + 1, true},
+		{"too old", code(current - 2), 0, 0, false},
+		{"too new", code(current + 2), 0, 0, false},
+		{"replayed", code(current), current, 0, false},
+		{"later code after earlier one", code(current + 1), current, current + 1, true},
+		{"wrong code", "000000", 0, 0, false},
+		{"too short", code(current)[:5], 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := VerifyTOTP(rfc6238Secret, tt.code, now, tt.lastCounter)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("VerifyTOTP(%q) = %d, %v, want %d, %v", tt.code, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes", len(codes), len(hashes))
+	}
+	for i, c := range codes {
+		if HashRecoveryCode(c) != hashes[i] {
+			t.Errorf("hash of %s doesn't match", c)
+		}
+		if HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(c, "-", ""))) != hashes[i] {
+			t.Errorf("hash of %s depends on case or dash", c)
+		}
+	}
+}
@@ -9,24 +9,144 @@ import (
 
 // Config struct holds all configuration for our application
 type Config struct {
-	Server ServerConfig `yaml:"server"`
-	Auth   Auth         `yaml:"auth"`
-	ServersConfigPath string `yaml:"servers_config_path"`
-	
+	Server            ServerConfig `yaml:"server"`
+	Auth              Auth         `yaml:"auth"`
+	ServersConfigPath string       `yaml:"servers_config_path"`
+	// OrphanPolicy decides what happens to server processes left running by
+	// a previous manager instance: "adopt" (default), "kill" or "ignore"
+	OrphanPolicy string `yaml:"orphan_policy"`
+	// AllowShellCommands enables servers in shell mode, whose command is
+	// run through /bin/bash -c instead of being executed directly
+	AllowShellCommands bool `yaml:"allow_shell_commands"`
+	// AutoPortRange is where ports are allocated for servers created with
+	// port "auto"
+	AutoPortRange PortRange `yaml:"auto_port_range"`
+	// CgroupRoot is the cgroup v2 directory where servers with resource
+	// limits get their cgroups
+	CgroupRoot string `yaml:"cgroup_root"`
+	// Metrics configures the Prometheus endpoint at /metrics
+	Metrics MetricsConfig `yaml:"metrics"`
+	// Session configures web UI logins
+	Session SessionConfig `yaml:"session"`
+	// CORSAllowedOrigins lists the origins other than the manager itself
+	// that may call the API from a browser
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins"`
+	// OIDC configures single sign-on with an OpenID Connect provider
+	OIDC OIDCConfig `yaml:"oidc"`
+	// Audit configures the rotation of the audit log
+	Audit AuditConfig `yaml:"audit"`
+	// RateLimit configures API rate limiting and the login lockout
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// Access restricts which client IPs may reach the manager
+	Access AccessConfig `yaml:"access"`
+	// PublicIPs are addresses of this host that aren't on its interfaces,
+	// e.g. behind NAT, accepted when checking that ACME domains point here
+	PublicIPs []string `yaml:"public_ips"`
 }
 
-// ACMEConfig struct holds ACME (Let's Encrypt) configuration
-
+// ACMEConfig struct holds ACME (Let's Encrypt) configuration. CA is the
+// directory URL of the ACME server, Let's Encrypt when empty.
+type ACMEConfig struct {
+	Email       string   `yaml:"email"`
+	Domains     []string `yaml:"domains"`
+	StoragePath string   `yaml:"storage_path"`
+	CA          string   `yaml:"ca"`
+}
 
 // ServerConfig struct holds server configuration
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port string `yaml:"port"`
+	Host string    `yaml:"host"`
+	Port string    `yaml:"port"`
+	TLS  TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig struct holds how the manager serves HTTPS. Mode is "static"
+// for CertFile and KeyFile, "self-signed" for a certificate generated on
+// first run, "acme" for certificates from ACME, or empty for plain HTTP.
+// RedirectHTTPPort starts a listener redirecting HTTP to HTTPS, which also
+// answers ACME HTTP challenges; HSTSMaxAge, in seconds, enables HSTS.
+type TLSConfig struct {
+	Mode             string     `yaml:"mode"`
+	CertFile         string     `yaml:"cert_file"`
+	KeyFile          string     `yaml:"key_file"`
+	ACME             ACMEConfig `yaml:"acme"`
+	RedirectHTTPPort string     `yaml:"redirect_http_port"`
+	HSTSMaxAge       int        `yaml:"hsts_max_age"`
+}
+
+// PortRange struct holds an inclusive range of TCP ports
+type PortRange struct {
+	Start int `yaml:"start"`
+	End   int `yaml:"end"`
+}
+
+// MetricsConfig struct holds the settings of the /metrics endpoint. It has
+// its own credentials so that scrapers don't need a manager login; without
+// any it is open.
+type MetricsConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	BearerToken  string `yaml:"bearer_token"`
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+}
+
+// SessionConfig struct holds the timeouts of web UI sessions. SecureCookie
+// forces the Secure flag on the session cookie, which is otherwise only set
+// for HTTPS requests.
+type SessionConfig struct {
+	IdleTimeoutMinutes   int  `yaml:"idle_timeout_minutes"`
+	AbsoluteTimeoutHours int  `yaml:"absolute_timeout_hours"`
+	SecureCookie         bool `yaml:"secure_cookie"`
+}
+
+// OIDCConfig struct holds the OpenID Connect provider and how its users
+// map onto manager users. Values of GroupsClaim become the user's groups,
+// and RoleMapping maps them to roles; users matching no mapping get
+// DefaultRole, or are refused when it is empty.
+type OIDCConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	Issuer        string            `yaml:"issuer"`
+	ClientID      string            `yaml:"client_id"`
+	ClientSecret  string            `yaml:"client_secret"`
+	RedirectURL   string            `yaml:"redirect_url"`
+	Scopes        []string          `yaml:"scopes"`
+	UsernameClaim string            `yaml:"username_claim"`
+	GroupsClaim   string            `yaml:"groups_claim"`
+	RoleMapping   map[string]string `yaml:"role_mapping"`
+	DefaultRole   string            `yaml:"default_role"`
+}
+
+// AuditConfig struct holds the rotation of the audit log, which is written
+// to audit.log next to servers.json
+type AuditConfig struct {
+	MaxSizeMB  int `yaml:"max_size_mb"`
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// RateLimitConfig struct holds the per-client API rate limit and how many
+// failed logins lock out a client IP or a username. Zero values use the
+// defaults; a negative requests_per_second turns rate limiting off.
+type RateLimitConfig struct {
+	RequestsPerSecond  float64 `yaml:"requests_per_second"`
+	Burst              int     `yaml:"burst"`
+	MaxFailuresPerIP   int     `yaml:"max_failures_per_ip"`
+	MaxFailuresPerUser int     `yaml:"max_failures_per_user"`
+	LockoutSeconds     int     `yaml:"lockout_seconds"`
+}
+
+// AccessConfig struct holds the CIDRs allowed to reach the web UI, the API
+// and /metrics; an empty list allows everyone. X-Forwarded-For is only
+// trusted from TrustedProxies.
+type AccessConfig struct {
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	UIAllowed      []string `yaml:"ui_allowed_cidrs"`
+	APIAllowed     []string `yaml:"api_allowed_cidrs"`
+	MetricsAllowed []string `yaml:"metrics_allowed_cidrs"`
 }
 
 // Auth struct holds authentication configuration
 type Auth struct {
-	Username string `yaml:"username"`
+	Username     string `yaml:"username"`
 	PasswordHash string `yaml:"password_hash"`
 }
 
@@ -65,4 +185,3 @@ func ValidateConfigPath(path string) error {
 	}
 	return nil
 }
-
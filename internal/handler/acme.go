@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"phpservermanager/internal/app"
+	"phpservermanager/internal/auth"
+)
+
+// revocationReasons are the RFC 5280 reason codes ACME CAs accept:
+// unspecified, keyCompromise, cACompromise (not for subscribers),
+// affiliationChanged, superseded and cessationOfOperation
+var revocationReasons = map[int]bool{0: true, 1: true, 3: true, 4: true, 5: true}
+
+// HandleGetACME handles the GET /api/servers/{id}/acme endpoint, returning
+// the ACME settings of a server and its issued certificates
+func (h *Handler) HandleGetACME(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !h.allowServer(w, r, id, auth.PermViewServers) {
+		return
+	}
+
+	status, err := h.App.GetACME(r.Context(), id)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleUpdateACME handles the PUT /api/servers/{id}/acme endpoint. Newly
+// enabled or changed domains must resolve to this host.
+func (h *Handler) HandleUpdateACME(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !h.allowServer(w, r, id, auth.PermManageServers) {
+		return
+	}
+
+	existing, exists := h.App.GetServer(id)
+	if !exists {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+
+	var settings app.ACMESettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	settings.Domains = normalizeDomains(settings.Domains)
+	if settings.StoragePath == "" {
+		settings.StoragePath = existing.ACMEStoragePath
+	}
+	if msg, ok := validateACME(settings.Enabled, settings.Email, settings.Domains); !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// Certificates are written as the manager, so only admins choose where
+	if settings.StoragePath != existing.ACMEStoragePath && !allow(w, r, auth.PermManageServers) {
+		return
+	}
+
+	if settings.Enabled && (!existing.ACMEEnabled || !reflect.DeepEqual(settings.Domains, existing.ACMEDomains)) {
+		if err := h.App.CheckDomains(r.Context(), settings.Domains); err != nil {
+			writeAppError(w, err)
+			return
+		}
+	}
+
+	if err := h.App.UpdateACME(r.Context(), id, settings); err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	h.HandleGetACME(w, r)
+}
+
+// HandleRenewACME handles the POST /api/servers/{id}/acme/renew endpoint,
+// renewing the certificates of a server right away
+func (h *Handler) HandleRenewACME(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !h.allowServer(w, r, id, auth.PermManageServers) {
+		return
+	}
+
+	certs, err := h.App.RenewCertificates(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, app.ErrServerNotFound) || errors.Is(err, app.ErrACMEDisabled) {
+			writeAppError(w, err)
+			return
+		}
+		http.Error(w, "Renewal failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certs)
+}
+
+// HandleRevokeACME handles the POST /api/servers/{id}/acme/revoke endpoint.
+// The body names the domain whose certificate is revoked and optionally an
+// RFC 5280 reason code.
+func (h *Handler) HandleRevokeACME(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !h.allowServer(w, r, id, auth.PermManageServers) {
+		return
+	}
+
+	var req struct {
+		Domain string `json:"domain"`
+		Reason int    `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Domain == "" {
+		http.Error(w, "Domain is required", http.StatusBadRequest)
+		return
+	}
+	if !revocationReasons[req.Reason] {
+		http.Error(w, "Reason must be 0, 1, 3, 4 or 5", http.StatusBadRequest)
+		return
+	}
+
+	err := h.App.RevokeCertificate(r.Context(), id, strings.ToLower(req.Domain), req.Reason)
+	if err != nil {
+		if errors.Is(err, app.ErrServerNotFound) || errors.Is(err, app.ErrACMEDisabled) || errors.Is(err, app.ErrUnknownDomain) {
+			writeAppError(w, err)
+			return
+		}
+		http.Error(w, "Revocation failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Certificate revoked."})
+}
+
+// normalizeDomains lowercases and trims domains, dropping empty and
+// duplicate ones
+func normalizeDomains(domains []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(d), "."))
+		if d != "" && !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// validateACME checks the ACME settings of a server, returning a message
+// describing the first problem found
+func validateACME(enabled bool, email string, domains []string) (string, bool) {
+	if enabled && len(domains) == 0 {
+		return "At least one domain is required to enable ACME", false
+	}
+	for _, d := range domains {
+		if !app.ValidDomain(d) {
+			return fmt.Sprintf("Invalid ACME domain %q; wildcards and IP addresses are not supported", d), false
+		}
+	}
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return "Invalid ACME email address", false
+		}
+	}
+	return "", true
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"phpservermanager/internal/app"
+	"phpservermanager/internal/auth"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// HandleGetAudit handles the GET /api/audit endpoint, returning audit log
+// entries newest first. They can be filtered by actor, action (or action
+// prefix such as "server."), server and an RFC 3339 since/until range.
+func (h *Handler) HandleGetAudit(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermViewAudit) {
+		return
+	}
+
+	q := r.URL.Query()
+	f := app.AuditFilter{
+		Actor:    q.Get("actor"),
+		Action:   q.Get("action"),
+		ServerID: q.Get("server"),
+		Limit:    defaultAuditLimit,
+	}
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid "+name+" time, use RFC 3339", http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxAuditLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		f.Limit = limit
+	}
+
+	entries, err := h.App.GetAudit(f)
+	if err != nil {
+		http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -2,66 +2,318 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"phpservermanager/internal/app"
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/metrics"
+	"phpservermanager/internal/oidc"
+	"phpservermanager/internal/ratelimit"
+	"phpservermanager/internal/server"
 )
 
 // Handler struct
 type Handler struct {
-	App *app.App
+	App      *app.App
+	Requests *metrics.Requests
+	// SecureCookies forces the Secure flag on session cookies
+	SecureCookies bool
+	// OIDC signs users in through an identity provider; nil when single
+	// sign-on is disabled
+	OIDC *oidc.Client
+	// Lockout throttles failed logins
+	Lockout *ratelimit.Lockout
 }
 
 // NewHandler creates a new Handler
 func NewHandler(a *app.App) *Handler {
-	return &Handler{App: a}
+	return &Handler{App: a, Requests: metrics.NewRequests(), Lockout: ratelimit.NewLockout(0, 0, 0)}
 }
 
 // HandleGetServers handles the GET /api/servers endpoint
 func (h *Handler) HandleGetServers(w http.ResponseWriter, r *http.Request) {
-	servers := h.App.GetServers()
+	u, _ := auth.FromContext(r.Context())
+	servers := []*server.Server{}
+	for _, s := range h.App.GetServers() {
+		if u == nil || !s.Allows(u, auth.PermViewServers) {
+			continue
+		}
+		for _, perm := range auth.ServerPermissions {
+			if s.Allows(u, perm) {
+				s.Permissions = append(s.Permissions, perm)
+			}
+		}
+		servers = append(servers, s)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(servers)
 }
 
+// serverRequest is the body accepted by the create and update server endpoints
+type serverRequest struct {
+	Name          string   `json:"name"`
+	Host          string   `json:"host"`
+	Port          string   `json:"port"`
+	Directory     string   `json:"directory"`
+	Command       string   `json:"command"`
+	Args          []string `json:"args"`
+	Shell         bool     `json:"shell"`
+	RestartPolicy string   `json:"restart_policy"`
+	MaxRetries    int      `json:"max_retries"`
+	RetryWindow   int      `json:"retry_window"`
+	StopTimeout   int      `json:"stop_timeout"`
+	Autostart     bool     `json:"autostart"`
+	StartOrder    int      `json:"start_order"`
+	StartDelay    int      `json:"start_delay"`
+
+	HealthCheck *server.HealthCheck `json:"health_check"`
+	Env         map[string]string   `json:"env"`
+	Secrets     map[string]string   `json:"secrets"`
+	LoadEnvFile bool                `json:"load_env_file"`
+	RunAsUser   string              `json:"run_as_user"`
+	RunAsGroup  string              `json:"run_as_group"`
+	Limits      *server.Limits      `json:"limits"`
+	Owner       string              `json:"owner"`
+	ACL         []auth.ACLEntry     `json:"acl"`
+
+	ACMEEnabled     bool     `json:"acme_enabled"`
+	ACMECertEmail   string   `json:"acme_cert_email"`
+	ACMEDomains     []string `json:"acme_domains"`
+	ACMEStoragePath string   `json:"acme_storage_path"`
+}
+
+// newServerRequest returns a request prefilled with the settings of s, so
+// that fields omitted from an update keep their current values. Maps are
+// left out so that a decoded map replaces the current one instead of being
+// merged into it; see keepOmitted. Slices and pointers are copied, since
+// decoding into them would otherwise change s, which is compared against
+// the request afterwards.
+func newServerRequest(s server.Server) serverRequest {
+	s = *s.Clone()
+	return serverRequest{
+		Name:          s.Name,
+		Host:          s.Host,
+		Port:          s.Port,
+		Directory:     s.Directory,
+		Command:       s.Command,
+		Args:          s.Args,
+		Shell:         s.Shell,
+		RestartPolicy: s.RestartPolicy,
+		MaxRetries:    s.MaxRetries,
+		RetryWindow:   s.RetryWindow,
+		StopTimeout:   s.StopTimeout,
+		Autostart:     s.Autostart,
+		StartOrder:    s.StartOrder,
+		StartDelay:    s.StartDelay,
+		HealthCheck:   s.HealthCheck,
+		LoadEnvFile:   s.LoadEnvFile,
+		RunAsUser:     s.RunAsUser,
+		RunAsGroup:    s.RunAsGroup,
+		Limits:        s.Limits,
+		Owner:         s.Owner,
+		ACL:           s.ACL,
+
+		ACMEEnabled:     s.ACMEEnabled,
+		ACMECertEmail:   s.ACMECertEmail,
+		ACMEDomains:     s.ACMEDomains,
+		ACMEStoragePath: s.ACMEStoragePath,
+	}
+}
+
+// keepOmitted restores the maps of s that were omitted from the request
+func (req *serverRequest) keepOmitted(s server.Server) {
+	if req.Env == nil {
+		req.Env = s.Env
+	}
+	if req.Secrets == nil {
+		req.Secrets = s.Secrets
+	}
+}
+
+// validate normalizes the command settings of the request and checks it,
+// returning a message describing the first problem found. Outside of shell
+// mode a command string is shorthand for args.
+func (req *serverRequest) validate() (string, bool) {
+	if !req.Shell && strings.TrimSpace(req.Command) != "" {
+		args, err := server.SplitCommand(req.Command)
+		if err != nil {
+			return fmt.Sprintf("Command can't be run without a shell (%v); provide args instead or enable shell mode", err), false
+		}
+		req.Args = args
+		req.Command = ""
+	}
+	if !req.Shell {
+		req.Command = ""
+	}
+
+	if req.Name == "" || req.Port == "" || req.Directory == "" {
+		return "Name, port, and directory are required", false
+	}
+
+	if req.Port != app.AutoPort {
+		if port, err := strconv.Atoi(req.Port); err != nil || port < 1 || port > 65535 {
+			return "Port must be a number between 1 and 65535 or \"auto\"", false
+		}
+	}
+
+	if req.Host != "" && !validateHost(req.Host) {
+		return "Invalid host format", false
+	}
+
+	if !server.ValidRestartPolicy(req.RestartPolicy) {
+		return "Restart policy must be one of never, on-failure or always", false
+	}
+
+	if req.MaxRetries < 0 || req.RetryWindow < 0 || req.StopTimeout < 0 || req.StartDelay < 0 {
+		return "max_retries, retry_window, stop_timeout and start_delay must not be negative", false
+	}
+
+	if req.HealthCheck != nil {
+		if err := req.HealthCheck.Validate(); err != nil {
+			return err.Error(), false
+		}
+	}
+
+	if req.Limits != nil {
+		if err := req.Limits.Validate(); err != nil {
+			return err.Error(), false
+		}
+	}
+
+	for _, e := range req.ACL {
+		if err := e.Validate(); err != nil {
+			return err.Error(), false
+		}
+	}
+
+	for _, vars := range []map[string]string{req.Env, req.Secrets} {
+		for name := range vars {
+			if !server.ValidEnvName(name) {
+				return fmt.Sprintf("Invalid environment variable name %q", name), false
+			}
+		}
+	}
+
+	req.ACMEDomains = normalizeDomains(req.ACMEDomains)
+	if msg, ok := validateACME(req.ACMEEnabled, req.ACMECertEmail, req.ACMEDomains); !ok {
+		return msg, false
+	}
+
+	if err := req.toServer().ValidateCommand(); err != nil {
+		return err.Error(), false
+	}
+
+	if err := req.toServer().ValidateRunAs(); err != nil {
+		return err.Error(), false
+	}
+
+	return "", true
+}
+
+// privilegedChange reports whether the request changes what a server runs,
+// as whom, where or with which limits. Owners and ACL grantees can't change
+// these, as they would let them run any command or serve any directory with
+// the manager's privileges.
+func (req *serverRequest) privilegedChange(s server.Server) bool {
+	return req.Directory != s.Directory ||
+		!reflect.DeepEqual(req.Limits, s.Limits) ||
+		req.RunAsUser != s.RunAsUser ||
+		req.RunAsGroup != s.RunAsGroup ||
+		req.Command != s.Command ||
+		req.Shell != s.Shell ||
+		!reflect.DeepEqual(req.Args, s.Args) ||
+		!reflect.DeepEqual(req.Env, s.Env) ||
+		!reflect.DeepEqual(req.Secrets, s.Secrets) ||
+		req.LoadEnvFile != s.LoadEnvFile ||
+		req.ACMEStoragePath != s.ACMEStoragePath
+}
+
+// toServer converts the request into a server configuration
+func (req *serverRequest) toServer() *server.Server {
+	return &server.Server{
+		Name:          req.Name,
+		Host:          req.Host,
+		Port:          req.Port,
+		Directory:     req.Directory,
+		Command:       req.Command,
+		Args:          req.Args,
+		Shell:         req.Shell,
+		RestartPolicy: req.RestartPolicy,
+		MaxRetries:    req.MaxRetries,
+		RetryWindow:   req.RetryWindow,
+		StopTimeout:   req.StopTimeout,
+		Autostart:     req.Autostart,
+		StartOrder:    req.StartOrder,
+		StartDelay:    req.StartDelay,
+		HealthCheck:   req.HealthCheck,
+		Env:           req.Env,
+		Secrets:       req.Secrets,
+		LoadEnvFile:   req.LoadEnvFile,
+		RunAsUser:     req.RunAsUser,
+		RunAsGroup:    req.RunAsGroup,
+		Limits:        req.Limits,
+		Owner:         req.Owner,
+		ACL:           req.ACL,
+
+		ACMEEnabled:     req.ACMEEnabled,
+		ACMECertEmail:   req.ACMECertEmail,
+		ACMEDomains:     req.ACMEDomains,
+		ACMEStoragePath: req.ACMEStoragePath,
+	}
+}
+
 // HandleCreateServer handles the POST /api/servers endpoint
 func (h *Handler) HandleCreateServer(w http.ResponseWriter, r *http.Request) {
-	var serverData struct {
-		Name      string `json:"name"`
-		Host      string `json:"host"`
-		Port      string `json:"port"`
-		Directory string `json:"directory"`
-		Command   string `json:"command"`
+	if !allow(w, r, auth.PermManageServers) {
+		return
 	}
 
+	var serverData serverRequest
+
 	if err := json.NewDecoder(r.Body).Decode(&serverData); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if serverData.Name == "" || serverData.Port == "" || serverData.Directory == "" {
-		http.Error(w, "Name, port, and directory are required", http.StatusBadRequest)
+	if msg, ok := serverData.validate(); !ok {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 
-	if _, err := strconv.Atoi(serverData.Port); err != nil {
-		http.Error(w, "Port must be a number", http.StatusBadRequest)
+	if serverData.Shell && !h.App.ShellCommandsAllowed() {
+		http.Error(w, "Shell commands are disabled; set allow_shell_commands in config.yaml to enable them", http.StatusForbidden)
 		return
 	}
 
-	if serverData.Host != "" && !validateHost(serverData.Host) {
-		http.Error(w, "Invalid host format", http.StatusBadRequest)
-		return
+	if serverData.ACMEEnabled {
+		if err := h.App.CheckDomains(r.Context(), serverData.ACMEDomains); err != nil {
+			writeAppError(w, err)
+			return
+		}
 	}
 
-	id := h.App.CreateServer(serverData.Name, serverData.Host, serverData.Port, serverData.Directory, serverData.Command)
+	if u, ok := auth.FromContext(r.Context()); ok && serverData.Owner == "" && h.App.AuthRequired() {
+		serverData.Owner = u.Username
+	}
+
+	spec := serverData.toServer()
+	id, err := h.App.CreateServer(r.Context(), spec)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"id": id})
+	json.NewEncoder(w).Encode(map[string]string{"id": id, "port": spec.Port})
 }
 
 // HandleUpdateServer handles the PUT /api/servers/{id} endpoint
@@ -69,37 +321,48 @@ func (h *Handler) HandleUpdateServer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var serverData struct {
-		Name      string `json:"name"`
-		Host      string `json:"host"`
-		Port      string `json:"port"`
-		Directory string `json:"directory"`
-		Command   string `json:"command"`
+	if !h.allowServer(w, r, id, auth.PermManageServers) {
+		return
 	}
 
+	existing, exists := h.App.GetServer(id)
+	if !exists {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+
+	serverData := newServerRequest(existing)
 	if err := json.NewDecoder(r.Body).Decode(&serverData); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	serverData.keepOmitted(existing)
 
-	if serverData.Name == "" || serverData.Port == "" || serverData.Directory == "" {
-		http.Error(w, "Name, port, and directory are required", http.StatusBadRequest)
+	if msg, ok := serverData.validate(); !ok {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 
-	if _, err := strconv.Atoi(serverData.Port); err != nil {
-		http.Error(w, "Port must be a number", http.StatusBadRequest)
-		return
+	if serverData.Owner != existing.Owner || !reflect.DeepEqual(serverData.ACL, existing.ACL) || serverData.privilegedChange(existing) {
+		if !allow(w, r, auth.PermManageServers) {
+			return
+		}
 	}
 
-	if serverData.Host != "" && !validateHost(serverData.Host) {
-		http.Error(w, "Invalid host format", http.StatusBadRequest)
+	if serverData.Shell && !h.App.ShellCommandsAllowed() {
+		http.Error(w, "Shell commands are disabled; set allow_shell_commands in config.yaml to enable them", http.StatusForbidden)
 		return
 	}
 
-	success := h.App.UpdateServer(id, serverData.Name, serverData.Host, serverData.Port, serverData.Directory, serverData.Command)
-	if !success {
-		http.Error(w, "Server not found", http.StatusNotFound)
+	if serverData.ACMEEnabled && (!existing.ACMEEnabled || !reflect.DeepEqual(serverData.ACMEDomains, existing.ACMEDomains)) {
+		if err := h.App.CheckDomains(r.Context(), serverData.ACMEDomains); err != nil {
+			writeAppError(w, err)
+			return
+		}
+	}
+
+	if err := h.App.UpdateServer(r.Context(), id, serverData.toServer()); err != nil {
+		writeAppError(w, err)
 		return
 	}
 
@@ -111,7 +374,11 @@ func (h *Handler) HandleDeleteServer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	success := h.App.DeleteServer(id)
+	if !h.allowServer(w, r, id, auth.PermManageServers) {
+		return
+	}
+
+	success := h.App.DeleteServer(r.Context(), id)
 	if !success {
 		http.Error(w, "Server not found", http.StatusNotFound)
 		return
@@ -125,7 +392,11 @@ func (h *Handler) HandleStartServer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	success := h.App.StartServer(id)
+	if !h.allowServer(w, r, id, auth.PermControlServers) {
+		return
+	}
+
+	success := h.App.StartServer(r.Context(), id)
 	if !success {
 		http.Error(w, "Failed to start server or server is already running", http.StatusBadRequest)
 		return
@@ -139,7 +410,11 @@ func (h *Handler) HandleStopServer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	success := h.App.StopServer(id)
+	if !h.allowServer(w, r, id, auth.PermControlServers) {
+		return
+	}
+
+	success := h.App.StopServer(r.Context(), id)
 	if !success {
 		http.Error(w, "Failed to stop server or server is already stopped", http.StatusBadRequest)
 		return
@@ -153,18 +428,160 @@ func (h *Handler) HandleServerStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	exists, running := h.App.GetServerStatus(id)
+	if !h.allowServer(w, r, id, auth.PermViewServers) {
+		return
+	}
+
+	status, exists := h.App.GetServerStatus(id)
 	if !exists {
 		http.Error(w, "Server not found", http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"running": running})
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleServerLogs handles the GET /api/servers/{id}/logs endpoint
+func (h *Handler) HandleServerLogs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if !h.allowServer(w, r, id, auth.PermViewLogs) {
+		return
+	}
+
+	tail, ok := parseTail(w, r)
+	if !ok {
+		return
+	}
+
+	lines, exists := h.App.GetServerLogs(id, tail)
+	if !exists {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lines)
+}
+
+// HandleStreamServerLogs handles the GET /api/servers/{id}/logs/stream endpoint.
+// It sends the last lines of output followed by new lines as Server-Sent Events
+// until the client disconnects.
+func (h *Handler) HandleStreamServerLogs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if !h.allowServer(w, r, id, auth.PermViewLogs) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	tail, ok := parseTail(w, r)
+	if !ok {
+		return
+	}
+
+	lines, ch, cancel, exists := h.App.SubscribeServerLogs(id, tail)
+	if !exists {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for _, line := range lines {
+		writeEvent(w, "log", line)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-ch:
+			writeEvent(w, "log", line)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// HandleEvents handles the GET /api/events endpoint. Server lifecycle events
+// are streamed as Server-Sent Events, optionally limited to the servers
+// given in one or more comma separated server_id query parameters.
+func (h *Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	u, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var serverIDs []string
+	for _, v := range r.URL.Query()["server_id"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				serverIDs = append(serverIDs, id)
+			}
+		}
+	}
+
+	ch, cancel := h.App.SubscribeEvents(serverIDs...)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			if !h.canSee(u, event.ServerID) {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\n", event.ID)
+			writeEvent(w, event.Type, event)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
 }
 
 // HandleGetServerSettings handles the GET /api/settings endpoint
 func (h *Handler) HandleGetServerSettings(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermViewServers) {
+		return
+	}
+
 	host, port := h.App.GetServerSettings()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -175,6 +592,10 @@ func (h *Handler) HandleGetServerSettings(w http.ResponseWriter, r *http.Request
 
 // HandleUpdateServerSettings handles the PUT /api/settings endpoint
 func (h *Handler) HandleUpdateServerSettings(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermManageSettings) {
+		return
+	}
+
 	var settingsData struct {
 		Host string `json:"host"`
 		Port string `json:"port"`
@@ -197,7 +618,7 @@ func (h *Handler) HandleUpdateServerSettings(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	success := h.App.UpdateServerSettings(settingsData.Host, settingsData.Port)
+	success := h.App.UpdateServerSettings(r.Context(), settingsData.Host, settingsData.Port)
 	if !success {
 		http.Error(w, "Failed to update server settings", http.StatusInternalServerError)
 		return
@@ -207,11 +628,15 @@ func (h *Handler) HandleUpdateServerSettings(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(map[string]string{"message": "Settings updated successfully. Restart the application to apply changes."})
 }
 
-// HandleUpdateAuth handles the PUT /api/auth endpoint
+// HandleUpdateAuth handles the PUT /api/auth endpoint, which changes the
+// credentials of the calling user. It needs their current password, and
+// their two-factor code when enabled.
 func (h *Handler) HandleUpdateAuth(w http.ResponseWriter, r *http.Request) {
 	var authData struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username        string `json:"username"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&authData); err != nil {
@@ -224,8 +649,41 @@ func (h *Handler) HandleUpdateAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.App.UpdateAuth(authData.Username, authData.Password); err != nil {
-		http.Error(w, "Failed to update auth settings", http.StatusInternalServerError)
+	if !auth.ValidUsername(authData.Username) {
+		http.Error(w, "Invalid username", http.StatusBadRequest)
+		return
+	}
+
+	// Without any users the first credentials set become the admin
+	if !h.App.AuthRequired() {
+		if err := h.App.CreateUser(r.Context(), authData.Username, authData.Password, auth.RoleAdmin, nil); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Auth settings updated successfully."})
+		return
+	}
+
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+	if u.Provider == auth.ProviderOIDC {
+		http.Error(w, "Single sign-on users are managed by the identity provider", http.StatusBadRequest)
+		return
+	}
+	if !h.reauthenticate(w, r, u, authData.CurrentPassword, authData.Code) {
+		return
+	}
+
+	// The session making the change stays signed in; other ones end
+	var session string
+	if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+		session = cookie.Value
+	}
+	if err := h.App.RenameUser(r.Context(), u.Username, authData.Username, authData.Password, session); err != nil {
+		writeAppError(w, err)
 		return
 	}
 
@@ -233,59 +691,122 @@ func (h *Handler) HandleUpdateAuth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Auth settings updated successfully."})
 }
 
-// HandleGetACMEStatus handles the GET /api/acme/status endpoint
-// func (h *Handler) HandleGetACMEStatus(w http.ResponseWriter, r *http.Request) {
-// 	status, err := h.App.GetACMEStatus()
-// 	if err != nil {
-// 		http.Error(w, err.Error(), http.StatusInternalServerError)
-// 		return
-// 	}
-// 	w.Header().Set("Content-Type", "application/json")
-// 	json.NewEncoder(w).Encode(map[string]bool{"enabled": status})
-// }
-
-// HandleUpdateACMESettings handles the PUT /api/acme/settings endpoint
-// func (h *Handler) HandleUpdateACMESettings(w http.ResponseWriter, r *http.Request) {
-// 	var acmeData struct {
-// 		Enabled bool     `json:"enabled"`
-// 		Email   string   `json:"email"`
-// 		Domains []string `json:"domains"`
-// 	}
-// 
-// 	if err := json.NewDecoder(r.Body).Decode(&acmeData); err != nil {
-// 		http.Error(w, err.Error(), http.StatusBadRequest)
-// 		return
-// 	}
-// 
-// 	if acmeData.Enabled && (acmeData.Email == "" || len(acmeData.Domains) == 0) {
-// 		http.Error(w, "Email and domains are required to enable ACME", http.StatusBadRequest)
-// 		return
-// 	}
-// 
-// 	if err := h.App.UpdateACMESettings(acmeData.Enabled, acmeData.Email, acmeData.Domains); err != nil {
-// 		http.Error(w, "Failed to update ACME settings", http.StatusInternalServerError)
-// 		return
-// 	}
-// 
-// 	w.Header().Set("Content-Type", "application/json")
-// 	json.NewEncoder(w).Encode(map[string]string{"message": "ACME settings updated successfully. Restart the application to apply changes."})
-// }
-
-// HandleRenewACME handles the POST /api/acme/renew endpoint
-// func (h *Handler) HandleRenewACME(w http.ResponseWriter, r *http.Request) {
-// 	if err := h.App.RenewACME(); err != nil {
-// 		http.Error(w, err.Error(), http.StatusInternalServerError)
-// 		return
-// 	}
-// 	w.Header().Set("Content-Type", "application/json")
-// 	json.NewEncoder(w).Encode(map[string]string{"message": "ACME certificate renewal initiated."})
-// }
+// reauthenticate checks the current password of u, and their second factor
+// if enabled, before a change to their credentials. Failures count towards
+// the login lockout.
+func (h *Handler) reauthenticate(w http.ResponseWriter, r *http.Request, u *auth.User, password, code string) bool {
+	ip := auth.ClientIP(r.Context())
+	if wait, ok := h.Lockout.Check(ip, u.Username); !ok {
+		ratelimit.Reject(w, wait)
+		return false
+	}
+
+	current, ok := h.App.Authenticate(u.Username, password)
+	if !ok {
+		h.Lockout.Fail(ip, u.Username)
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+	if current.TOTPEnabled {
+		if code == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "Two-factor code required", "totp_required": true})
+			return false
+		}
+		if !h.App.VerifySecondFactor(u.Username, code) {
+			h.Lockout.Fail(ip, u.Username)
+			http.Error(w, "Invalid two-factor code", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
 
 // ServeStatic serves static files
 func ServeStatic(fs http.FileSystem) http.Handler {
 	return http.FileServer(fs)
 }
 
+// allow reports whether the user making r has perm, answering with 403
+// Forbidden if not
+func allow(w http.ResponseWriter, r *http.Request, perm string) bool {
+	if u, ok := auth.FromContext(r.Context()); ok && u.Can(perm) {
+		return true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// allowServer checks that the user making r has perm on server id. Servers
+// the user can't see are reported as not found.
+func (h *Handler) allowServer(w http.ResponseWriter, r *http.Request, id, perm string) bool {
+	u, ok := auth.FromContext(r.Context())
+	s, exists := h.App.GetServer(id)
+	if !ok || !exists || !s.Allows(u, auth.PermViewServers) {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return false
+	}
+	if !s.Allows(u, perm) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// canSee reports whether u may see server id. Events of deleted servers are
+// only shown to users who can see every server.
+func (h *Handler) canSee(u *auth.User, id string) bool {
+	if s, exists := h.App.GetServer(id); exists {
+		return s.Allows(u, auth.PermViewServers)
+	}
+	return u.Can(auth.PermViewServers)
+}
+
+// writeAppError writes the response for an error returned by the app
+func writeAppError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, app.ErrServerNotFound):
+		http.Error(w, "Server not found", http.StatusNotFound)
+	case errors.Is(err, app.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, app.ErrTokenNotFound):
+		http.Error(w, "Token not found", http.StatusNotFound)
+	case errors.Is(err, app.ErrInvalidCode), errors.Is(err, app.ErrNoEnrollment),
+		errors.Is(err, app.ErrDomainNotLocal), errors.Is(err, app.ErrUnknownDomain):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, app.ErrPortConflict), errors.Is(err, app.ErrUserExists), errors.Is(err, app.ErrLastAdmin),
+		errors.Is(err, app.ErrACMEDisabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseTail reads the optional "tail" query parameter, writing an error
+// response and returning false if it is invalid
+func parseTail(w http.ResponseWriter, r *http.Request) (int, bool) {
+	tail := 100
+	if v := r.URL.Query().Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "tail must be a non-negative number", http.StatusBadRequest)
+			return 0, false
+		}
+		tail = n
+	}
+	return tail, true
+}
+
+// writeEvent writes v as a JSON encoded Server-Sent Event
+func writeEvent(w http.ResponseWriter, event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
 func validateHost(host string) bool {
 	if host == "" {
 		return false
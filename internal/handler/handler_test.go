@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"phpservermanager/internal/app"
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/config"
+	"phpservermanager/internal/server"
+)
+
+// newTestHandler returns a handler whose servers are saved in a temporary
+// directory, with one server owned by "owner" serving docroot
+func newTestHandler(t *testing.T) (h *Handler, id, docroot string) {
+	t.Helper()
+	// Servers are saved in the background, so the directory may still be
+	// written to when the test ends
+	dir, err := os.MkdirTemp("", "handler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	docroot = filepath.Join(dir, "www")
+	if err := os.Mkdir(docroot, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	a := app.NewApp(&config.Config{ServersConfigPath: filepath.Join(dir, "servers.json")})
+	id, err = a.CreateServer(auth.NewContext(context.Background(), &auth.User{Username: "admin", Role: auth.RoleAdmin}), &server.Server{
+		Name:      "site",
+		Host:      "127.0.0.1",
+		Port:      "9001",
+		Directory: docroot,
+		Args:      []string{"frankenphp", "php-server", "-r", "{directory}"},
+		Owner:     "owner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(a), id, docroot
+}
+
+func updateServer(h *Handler, u *auth.User, id, body string) int {
+	r := httptest.NewRequest(http.MethodPut, "/api/servers/"+id, strings.NewReader(body))
+	r = mux.SetURLVars(r.WithContext(auth.NewContext(r.Context(), u)), map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	h.HandleUpdateServer(w, r)
+	return w.Code
+}
+
+func TestUpdateServerPrivilegedFields(t *testing.T) {
+	h, id, _ := newTestHandler(t)
+	owner := &auth.User{Username: "owner", Role: auth.RoleMember}
+	admin := &auth.User{Username: "admin", Role: auth.RoleAdmin}
+
+	other := t.TempDir()
+	privileged := map[string]string{
+		"directory":         `{"directory": "` + other + `"}`,
+		"limits":            `{"limits": {"memory_mb": 64}}`,
+		"run_as_user":       `{"run_as_user": "root"}`,
+		"run_as_group":      `{"run_as_group": "root"}`,
+		"command":           `{"command": "php -S {listen_addr}"}`,
+		"args":              `{"args": ["php", "-S", "{listen_addr}"]}`,
+		"shell":             `{"shell": true, "command": "php -S {listen_addr}"}`,
+		"env":               `{"env": {"LD_PRELOAD": "/tmp/x.so"}}`,
+		"secrets":           `{"secrets": {"TOKEN": "x"}}`,
+		"load_env_file":     `{"load_env_file": true}`,
+		"acme_storage_path": `{"acme_storage_path": "/etc"}`,
+		"owner":             `{"owner": "someone"}`,
+		"acl":               `{"acl": [{"user": "someone", "permissions": ["servers:view"]}]}`,
+	}
+	for field, body := range privileged {
+		if code := updateServer(h, owner, id, body); code != http.StatusForbidden {
+			t.Errorf("owner changing %s got %d, want 403", field, code)
+		}
+	}
+	if s, _ := h.App.GetServer(id); s.Directory == other || s.Limits != nil || s.RunAsUser != "" || len(s.Env) != 0 {
+		t.Fatalf("a refused update changed the server: %+v", s)
+	}
+
+	if code := updateServer(h, owner, id, `{"name": "renamed", "restart_policy": "always"}`); code != http.StatusOK {
+		t.Errorf("owner changing the name got %d, want 200", code)
+	}
+	if code := updateServer(h, admin, id, privileged["directory"]); code != http.StatusOK {
+		t.Errorf("admin changing the directory got %d, want 200", code)
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"phpservermanager/internal/app"
+	"phpservermanager/internal/metrics"
+	"phpservermanager/internal/server"
+)
+
+// serverMetrics is everything exported about one server
+type serverMetrics struct {
+	server *server.Server
+	status server.Status
+	certs  []app.Certificate
+}
+
+func (m serverMetrics) labels(extra ...string) []string {
+	return append([]string{"server_id", m.server.ID, "name", m.server.Name}, extra...)
+}
+
+// HandleMetrics handles the GET /metrics endpoint in the Prometheus text
+// format
+func (h *Handler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	var servers []serverMetrics
+	for _, s := range h.App.GetServers() {
+		status, ok := h.App.GetServerStatus(s.ID)
+		if !ok {
+			continue
+		}
+		certs, _ := h.App.GetCertificates(r.Context(), s.ID)
+		servers = append(servers, serverMetrics{server: s, status: status, certs: certs})
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		a, errA := strconv.Atoi(servers[i].server.ID)
+		b, errB := strconv.Atoi(servers[j].server.ID)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return servers[i].server.ID < servers[j].server.ID
+	})
+
+	var buf bytes.Buffer
+	mw := metrics.NewWriter(&buf)
+
+	mw.Gauge("servers", "Number of configured servers.", float64(len(servers)))
+	for _, m := range servers {
+		up := 0.0
+		if m.status.Running {
+			up = 1
+		}
+		mw.Gauge("server_up", "Whether the server process is running.", up, m.labels()...)
+	}
+	for _, m := range servers {
+		if m.status.Health == "" {
+			continue
+		}
+		for _, health := range []string{server.HealthHealthy, server.HealthUnhealthy, server.HealthUnknown} {
+			value := 0.0
+			if m.status.Health == health {
+				value = 1
+			}
+			mw.Gauge("server_health", "Health check status of the server, 1 for the current status.", value, m.labels("status", health)...)
+		}
+	}
+	for _, m := range servers {
+		mw.Counter("server_restarts_total", "Automatic restarts of the server after it crashed or failed its health checks.", float64(m.status.RestartCount), m.labels()...)
+	}
+	for _, m := range servers {
+		mw.Gauge("server_last_exit_code", "Exit code of the last server process, -1 if it was killed by a signal.", float64(m.server.LastExitCode), m.labels()...)
+	}
+
+	processGauges := []struct {
+		name, help string
+		value      func(*server.ProcessMetrics) float64
+	}{
+		{"server_uptime_seconds", "Time since the server process was started.", func(p *server.ProcessMetrics) float64 { return float64(p.Uptime) }},
+		{"server_memory_rss_bytes", "Resident memory of the server's process group.", func(p *server.ProcessMetrics) float64 { return float64(p.RSSBytes) }},
+		{"server_cpu_percent", "CPU usage of the server's process group, 100 per fully used CPU.", func(p *server.ProcessMetrics) float64 { return p.CPUPercent }},
+		{"server_open_fds", "Open file descriptors of the server's process group.", func(p *server.ProcessMetrics) float64 { return float64(p.OpenFDs) }},
+		{"server_processes", "Processes in the server's process group.", func(p *server.ProcessMetrics) float64 { return float64(p.Children + 1) }},
+	}
+	for _, g := range processGauges {
+		for _, m := range servers {
+			if m.status.Metrics != nil {
+				mw.Gauge(g.name, g.help, g.value(m.status.Metrics), m.labels()...)
+			}
+		}
+	}
+
+	for _, m := range servers {
+		if u := m.status.Usage; u != nil && u.Enforcement == server.EnforcementCgroup {
+			mw.Gauge("server_cgroup_memory_bytes", "Memory charged to the server's cgroup.", float64(u.MemoryBytes), m.labels()...)
+		}
+	}
+	for _, m := range servers {
+		if u := m.status.Usage; u != nil && u.Enforcement == server.EnforcementCgroup {
+			mw.Counter("server_cgroup_cpu_seconds_total", "CPU time used by the server's cgroup.", float64(u.CPUUsageUsec)/1e6, m.labels()...)
+		}
+	}
+	for _, m := range servers {
+		if u := m.status.Usage; u != nil && u.MemoryMaxBytes > 0 {
+			mw.Gauge("server_memory_limit_bytes", "Memory limit of the server.", float64(u.MemoryMaxBytes), m.labels()...)
+		}
+	}
+
+	for _, m := range servers {
+		for _, c := range m.certs {
+			mw.Gauge("certificate_expiry_timestamp_seconds", "Expiry time of a server's ACME certificate as a Unix timestamp.", float64(c.NotAfter.Unix()),
+				m.labels("domain", c.Domain, "issuer", c.Issuer)...)
+		}
+	}
+
+	h.Requests.WriteTo(mw)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
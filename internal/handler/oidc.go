@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"phpservermanager/internal/app"
+)
+
+// oidcStateCookie binds a single sign-on login to the browser that started
+// it
+const oidcStateCookie = "psm_oidc_state"
+
+// HandleLoginOptions handles the GET /api/login endpoint, telling the web
+// UI which ways of signing in are available
+func (h *Handler) HandleLoginOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"password": true, "oidc": h.OIDC != nil})
+}
+
+// HandleOIDCLogin handles the GET /api/oidc/login endpoint, sending the
+// browser to the identity provider
+func (h *Handler) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	state, authURL, err := h.OIDC.AuthCodeURL(r.Context(), h.oidcRedirectURL(r))
+	if err != nil {
+		fmt.Printf("Error starting single sign-on: %v\n", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Lax, as the provider sends the browser back with a cross-site redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   h.secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleOIDCCallback handles the GET /api/oidc/callback endpoint the
+// identity provider returns to. It signs the user in with a session and
+// sends them to the web UI.
+func (h *Handler) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "Sign in failed: "+e+" "+q.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value == "" || cookie.Value != q.Get("state") {
+		http.Error(w, "Sign in failed: state mismatch", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc", MaxAge: -1, HttpOnly: true})
+
+	id, err := h.OIDC.Exchange(r.Context(), q.Get("state"), q.Get("code"))
+	if err != nil {
+		fmt.Printf("Error finishing single sign-on: %v\n", err)
+		http.Error(w, "Sign in failed", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := h.App.LoginOIDC(id.Username, id.Role, id.Groups)
+	if err == app.ErrUserExists {
+		http.Error(w, "Sign in failed: a local user with this name exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	sid, _, err := h.App.CreateSession(u.Username)
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, h.sessionCookie(r, sid, 0))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// oidcRedirectURL is the callback URL of the manager as reached by r
+func (h *Handler) oidcRedirectURL(r *http.Request) string {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: "/api/oidc/callback"}
+	if h.secureRequest(r) {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
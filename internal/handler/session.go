@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/ratelimit"
+)
+
+// HandleLogin handles the POST /api/login endpoint. It starts a session
+// and sets the session cookie; the CSRF token in the response must be sent
+// in the X-CSRF-Token header of state-changing requests. Users with TOTP
+// enabled also send a code from their authenticator app or a recovery code.
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ip := auth.ClientIP(r.Context())
+	if wait, ok := h.Lockout.Check(ip, req.Username); !ok {
+		ratelimit.Reject(w, wait)
+		return
+	}
+
+	u, ok := h.App.Authenticate(req.Username, req.Password)
+	if !ok {
+		h.Lockout.Fail(ip, req.Username)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	if u.TOTPEnabled {
+		if req.Code == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "Two-factor code required", "totp_required": true})
+			return
+		}
+		if !h.App.VerifySecondFactor(u.Username, req.Code) {
+			h.Lockout.Fail(ip, req.Username)
+			http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+	}
+	h.Lockout.Succeed(u.Username)
+
+	id, csrfToken, err := h.App.CreateSession(u.Username)
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, h.sessionCookie(r, id, 0))
+	writeSessionUser(w, u, csrfToken)
+}
+
+// HandleLogout handles the POST /api/logout endpoint
+func (h *Handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+		h.App.DeleteSession(cookie.Value)
+	}
+	http.SetCookie(w, h.sessionCookie(r, "", -1))
+	w.WriteHeader(http.StatusOK)
+}
+
+// sessionCookie builds the session cookie, Secure over HTTPS
+func (h *Handler) sessionCookie(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// secureRequest reports whether r reached the manager over HTTPS, directly
+// or through a proxy, or cookies should be Secure anyway
+func (h *Handler) secureRequest(r *http.Request) bool {
+	return h.SecureCookies || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// writeSessionUser describes a user and their permissions, with the CSRF
+// token of their session if they have one
+func writeSessionUser(w http.ResponseWriter, u *auth.User, csrfToken string) {
+	permissions := []string{}
+	for _, p := range auth.Permissions(auth.RoleAdmin) {
+		if u.Can(p) {
+			permissions = append(permissions, p)
+		}
+	}
+
+	resp := map[string]interface{}{
+		"username":     u.Username,
+		"role":         u.Role,
+		"groups":       u.Groups,
+		"totp_enabled": u.TOTPEnabled,
+		"permissions":  permissions,
+	}
+	if csrfToken != "" {
+		resp["csrf_token"] = csrfToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
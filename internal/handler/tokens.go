@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"phpservermanager/internal/auth"
+)
+
+// tokenRequest is the body accepted by the create token endpoint
+type tokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// accountUser returns the user making r for managing their own account,
+// such as their tokens or second factor. This needs a real user account,
+// authenticated by password or session rather than an API token.
+func (h *Handler) accountUser(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	u, ok := auth.FromContext(r.Context())
+	if !ok || !h.App.AuthRequired() {
+		http.Error(w, "This needs a user account; set credentials first", http.StatusBadRequest)
+		return nil, false
+	}
+	if u.Scopes != nil {
+		http.Error(w, "API tokens can't manage accounts", http.StatusForbidden)
+		return nil, false
+	}
+	return u, true
+}
+
+// HandleGetTokens handles the GET /api/tokens endpoint. Admins see every
+// token, other users their own.
+func (h *Handler) HandleGetTokens(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	owner := u.Username
+	if u.Can(auth.PermManageUsers) {
+		owner = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.App.GetTokens(owner))
+}
+
+// HandleCreateToken handles the POST /api/tokens endpoint. The token is
+// only returned in this response.
+func (h *Handler) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidPermission(scope) {
+			http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	token, t, err := h.App.CreateToken(r.Context(), u.Username, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		auth.Token
+		Secret string `json:"token"`
+	}{t, token})
+}
+
+// HandleDeleteToken handles the DELETE /api/tokens/{id} endpoint
+func (h *Handler) HandleDeleteToken(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	owner := u.Username
+	if u.Can(auth.PermManageUsers) {
+		owner = ""
+	}
+
+	if err := h.App.DeleteToken(r.Context(), mux.Vars(r)["id"], owner); err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"phpservermanager/internal/app"
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/ratelimit"
+)
+
+// HandleEnrollTOTP handles the POST /api/auth/totp/enroll endpoint. It
+// returns a new secret and its otpauth:// URI for the authenticator app;
+// the second factor is enabled once a code is verified.
+func (h *Handler) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := h.App.BeginTOTPEnrollment(u.Username)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"secret": secret, "uri": uri})
+}
+
+// HandleVerifyTOTP handles the POST /api/auth/totp/verify endpoint,
+// enabling the enrolled second factor and returning recovery codes, which
+// are only shown this once
+func (h *Handler) HandleVerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ip := auth.ClientIP(r.Context())
+	if wait, ok := h.Lockout.Check(ip, u.Username); !ok {
+		ratelimit.Reject(w, wait)
+		return
+	}
+	codes, err := h.App.ConfirmTOTPEnrollment(r.Context(), u.Username, req.Code)
+	if err != nil {
+		if errors.Is(err, app.ErrInvalidCode) {
+			h.Lockout.Fail(ip, u.Username)
+		}
+		writeAppError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// HandleDisableTOTP handles the DELETE /api/auth/totp endpoint. Users turn
+// off their own second factor by proving they still have it.
+func (h *Handler) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ip := auth.ClientIP(r.Context())
+	if wait, ok := h.Lockout.Check(ip, u.Username); !ok {
+		ratelimit.Reject(w, wait)
+		return
+	}
+	if !h.App.VerifySecondFactor(u.Username, req.Code) {
+		h.Lockout.Fail(ip, u.Username)
+		http.Error(w, "Invalid two-factor code", http.StatusForbidden)
+		return
+	}
+	if err := h.App.DisableTOTP(r.Context(), u.Username); err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleResetUserTOTP handles the DELETE /api/users/{username}/totp
+// endpoint, letting admins remove the second factor of a user who lost it
+func (h *Handler) HandleResetUserTOTP(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermManageUsers) {
+		return
+	}
+
+	if err := h.App.DisableTOTP(r.Context(), mux.Vars(r)["username"]); err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
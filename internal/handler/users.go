@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"phpservermanager/internal/auth"
+)
+
+// userRequest is the body accepted by the create and update user endpoints
+type userRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Role     string   `json:"role"`
+	Groups   []string `json:"groups"`
+}
+
+// validateGroups returns a message describing the first invalid group name
+func (req *userRequest) validateGroups() (string, bool) {
+	for _, g := range req.Groups {
+		if !auth.ValidUsername(g) {
+			return fmt.Sprintf("Invalid group name %q", g), false
+		}
+	}
+	return "", true
+}
+
+// HandleGetAuth handles the GET /api/auth endpoint, describing the calling
+// user and their permissions. Session users also get their CSRF token, so
+// the web UI can pick it up again after a reload.
+func (h *Handler) HandleGetAuth(w http.ResponseWriter, r *http.Request) {
+	u, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	csrfToken, _ := auth.SessionCSRFToken(r.Context())
+	writeSessionUser(w, u, csrfToken)
+}
+
+// HandleGetUsers handles the GET /api/users endpoint
+func (h *Handler) HandleGetUsers(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermManageUsers) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.App.GetUsers())
+}
+
+// HandleGetUser handles the GET /api/users/{username} endpoint
+func (h *Handler) HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermManageUsers) {
+		return
+	}
+
+	u, exists := h.App.GetUser(mux.Vars(r)["username"])
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// HandleCreateUser handles the POST /api/users endpoint
+func (h *Handler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermManageUsers) {
+		return
+	}
+
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !auth.ValidUsername(req.Username) {
+		http.Error(w, "Username must be 1-64 letters, digits or . _ @ -", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		http.Error(w, "Password is required", http.StatusBadRequest)
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		http.Error(w, "Role must be one of admin, operator, viewer or member", http.StatusBadRequest)
+		return
+	}
+
+	if msg, ok := req.validateGroups(); !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.App.CreateUser(r.Context(), req.Username, req.Password, req.Role, req.Groups); err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	u, _ := h.App.GetUser(req.Username)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(u)
+}
+
+// HandleUpdateUser handles the PUT /api/users/{username} endpoint. Omitted
+// password and role are left unchanged.
+func (h *Handler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermManageUsers) {
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Role != "" && !auth.ValidRole(req.Role) {
+		http.Error(w, "Role must be one of admin, operator, viewer or member", http.StatusBadRequest)
+		return
+	}
+
+	if msg, ok := req.validateGroups(); !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.App.UpdateUser(r.Context(), username, req.Password, req.Role, req.Groups); err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	u, _ := h.App.GetUser(username)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// HandleDeleteUser handles the DELETE /api/users/{username} endpoint
+func (h *Handler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, auth.PermManageUsers) {
+		return
+	}
+
+	if err := h.App.DeleteUser(r.Context(), mux.Vars(r)["username"]); err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,91 @@
+// Package metrics writes metrics in the Prometheus text exposition format
+// and records API request counts and latencies.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Namespace prefixes the name of every metric
+const Namespace = "phpservermanager_"
+
+// Writer writes metric families in the Prometheus text format. Samples of
+// one family must be written together; the HELP and TYPE lines are emitted
+// before the first of them.
+type Writer struct {
+	w    io.Writer
+	last string
+}
+
+// NewWriter returns a Writer writing to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Gauge writes a gauge sample. labels are name/value pairs.
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.sample(name, "gauge", help, "", value, labels)
+}
+
+// Counter writes a counter sample. labels are name/value pairs.
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.sample(name, "counter", help, "", value, labels)
+}
+
+// Histogram writes the buckets, sum and count of a histogram. counts holds
+// the cumulative count for each of bounds.
+func (w *Writer) Histogram(name, help string, bounds []float64, counts []uint64, sum float64, count uint64, labels ...string) {
+	for i, bound := range bounds {
+		le := append(append([]string(nil), labels...), "le", formatFloat(bound))
+		w.sample(name, "histogram", help, "_bucket", float64(counts[i]), le)
+	}
+	w.sample(name, "histogram", help, "_bucket", float64(count), append(append([]string(nil), labels...), "le", "+Inf"))
+	w.sample(name, "histogram", help, "_sum", sum, labels)
+	w.sample(name, "histogram", help, "_count", float64(count), labels)
+}
+
+func (w *Writer) sample(name, kind, help, suffix string, value float64, labels []string) {
+	name = Namespace + name
+	if name != w.last {
+		fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+		w.last = name
+	}
+
+	var b strings.Builder
+	b.WriteString(name + suffix)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(w.w, "%s %s\n", b.String(), formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
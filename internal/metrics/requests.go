@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DurationBuckets are the upper bounds, in seconds, of the request latency
+// histogram
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	route  string
+	method string
+	code   string
+}
+
+type durationKey struct {
+	route  string
+	method string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Requests counts API requests and their latencies by route template,
+// method and status code
+type Requests struct {
+	mu        sync.Mutex
+	counts    map[requestKey]uint64
+	durations map[durationKey]*histogram
+}
+
+// NewRequests creates an empty request recorder
+func NewRequests() *Requests {
+	return &Requests{
+		counts:    make(map[requestKey]uint64),
+		durations: make(map[durationKey]*histogram),
+	}
+}
+
+// Middleware records every request served by a mux router. Routes are
+// identified by their path template, e.g. /api/servers/{id}, so that the
+// number of series stays bounded.
+func (rq *Requests) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		rq.observe(route, r.Method, rec.status, time.Since(start))
+	})
+}
+
+func (rq *Requests) observe(route, method string, status int, d time.Duration) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	rq.counts[requestKey{route, method, strconv.Itoa(status)}]++
+
+	key := durationKey{route, method}
+	h := rq.durations[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(DurationBuckets))}
+		rq.durations[key] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range DurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// WriteTo writes the request counters and latency histograms to w
+func (rq *Requests) WriteTo(w *Writer) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	counts := make([]requestKey, 0, len(rq.counts))
+	for k := range rq.counts {
+		counts = append(counts, k)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	for _, k := range counts {
+		w.Counter("http_requests_total", "API requests by route, method and status code.", float64(rq.counts[k]),
+			"route", k.route, "method", k.method, "code", k.code)
+	}
+
+	durations := make([]durationKey, 0, len(rq.durations))
+	for k := range rq.durations {
+		durations = append(durations, k)
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		a, b := durations[i], durations[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		return a.method < b.method
+	})
+	for _, k := range durations {
+		h := rq.durations[k]
+		w.Histogram("http_request_duration_seconds", "API request latency by route and method.", DurationBuckets, h.counts, h.sum, h.count,
+			"route", k.route, "method", k.method)
+	}
+}
+
+// statusRecorder captures the status code written by a handler. It passes
+// flushes through so that event streams keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
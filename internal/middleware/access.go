@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"phpservermanager/internal/auth"
+)
+
+// ParseCIDRs parses a list of CIDRs from config.yaml. Plain IP addresses
+// stand for themselves alone.
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address or CIDR %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether ip is in any of nets
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP stores the IP address of the client in the request context, for
+// the access restrictions, rate limits and audit log. X-Forwarded-For is
+// only honored from trusted proxies: the client is the last address in it
+// that isn't a trusted proxy itself.
+func ClientIP(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+
+			if parsed := net.ParseIP(ip); parsed != nil && containsIP(trustedProxies, parsed) {
+				var hops []string
+				for _, h := range r.Header.Values("X-Forwarded-For") {
+					hops = append(hops, strings.Split(h, ",")...)
+				}
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop := net.ParseIP(strings.TrimSpace(hops[i]))
+					if hop == nil {
+						break
+					}
+					ip = hop.String()
+					if !containsIP(trustedProxies, hop) {
+						break
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.NewClientIPContext(r.Context(), ip)))
+		})
+	}
+}
+
+// AllowCIDRs only lets clients whose IP is in nets through, answering
+// others with 403 Forbidden. An empty list allows every client.
+func AllowCIDRs(nets []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(nets) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := net.ParseIP(auth.ClientIP(r.Context()))
+			if ip == nil || !containsIP(nets, ip) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"phpservermanager/internal/auth"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		in      []string
+		want    []string
+		wantErr bool
+	}{
+		{in: nil, want: []string{}},
+		{in: []string{"10.0.0.0/8", " 192.168.1.0/24 "}, want: []string{"10.0.0.0/8", "192.168.1.0/24"}},
+		{in: []string{"10.1.2.3/8"}, want: []string{"10.0.0.0/8"}},
+		{in: []string{"127.0.0.1"}, want: []string{"127.0.0.1/32"}},
+		{in: []string{"::1", "fd00::/8"}, want: []string{"::1/128", "fd00::/8"}},
+		{in: []string{"localhost"}, wantErr: true},
+		{in: []string{"10.0.0.0/33"}, wantErr: true},
+		{in: []string{""}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		nets, err := ParseCIDRs(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCIDRs(%q) accepted invalid input", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCIDRs(%q): %v", tt.in, err)
+			continue
+		}
+		var got []string
+		for _, n := range nets {
+			got = append(got, n.String())
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseCIDRs(%q) = %v, want %v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseCIDRs(%q) = %v, want %v", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"no header", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"untrusted peer", "203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted IPv6 proxy", "[::1]:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy without header", "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"spoofed first hop", "10.0.0.1:4000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1:4000", []string{"198.51.100.1, 10.0.0.7, 10.0.0.8"}, "198.51.100.1"},
+		{"several headers", "10.0.0.1:4000", []string{"1.2.3.4", "198.51.100.1, 10.0.0.7"}, "198.51.100.1"},
+		{"only proxies", "10.0.0.1:4000", []string{"10.0.0.7, 10.0.0.8"}, "10.0.0.7"},
+		{"garbage hop", "10.0.0.1:4000", []string{"198.51.100.1, unknown"}, "10.0.0.1"},
+		{"garbage behind client", "10.0.0.1:4000", []string{"unknown, 198.51.100.1"}, "198.51.100.1"},
+		{"remote without port", "203.0.113.5", nil, "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = auth.ClientIP(r.Context())
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"192.168.0.0/16", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		open bool
+		ip   string
+		want int
+	}{
+		{false, "192.168.4.2", http.StatusOK},
+		{false, "2001:db8::1", http.StatusOK},
+		{false, "10.0.0.1", http.StatusForbidden},
+		{false, "", http.StatusForbidden},
+		{true, "10.0.0.1", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		allowed := nets
+		if tt.open {
+			allowed = nil
+		}
+		h := AllowCIDRs(allowed)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(auth.NewClientIPContext(r.Context(), tt.ip))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("client %q got %d, want %d", tt.ip, w.Code, tt.want)
+		}
+	}
+}
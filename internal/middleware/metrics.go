@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/config"
+	"phpservermanager/internal/ratelimit"
+)
+
+// MetricsAuth protects the metrics endpoint with its own bearer token or
+// Basic auth credentials. Without either configured the endpoint is open.
+// Failed attempts count toward lockout of the client IP.
+func MetricsAuth(cfg config.MetricsConfig, lockout *ratelimit.Lockout) func(http.Handler) http.Handler {
+	// bcrypt is slow on purpose, so a password that matched is remembered
+	// by its SHA-256 hash rather than checked again on every scrape
+	var mu sync.Mutex
+	var verified []byte
+	checkPassword := func(pass string) bool {
+		sum := sha256.Sum256([]byte(pass))
+		mu.Lock()
+		known := verified != nil && subtle.ConstantTimeCompare(sum[:], verified) == 1
+		mu.Unlock()
+		if known {
+			return true
+		}
+		if bcrypt.CompareHashAndPassword([]byte(cfg.PasswordHash), []byte(pass)) != nil {
+			return false
+		}
+		mu.Lock()
+		verified = sum[:]
+		mu.Unlock()
+		return true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenSet := cfg.BearerToken != ""
+			basicSet := cfg.Username != "" && cfg.PasswordHash != ""
+			if !tokenSet && !basicSet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ip := auth.ClientIP(r.Context())
+			if wait, ok := lockout.Check(ip, ""); !ok {
+				ratelimit.Reject(w, wait)
+				return
+			}
+
+			if tokenSet {
+				if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+					subtle.ConstantTimeCompare([]byte(token), []byte(cfg.BearerToken)) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if basicSet {
+				user, pass, ok := r.BasicAuth()
+				if ok && user == cfg.Username && checkPassword(pass) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Basic realm="Metrics"`)
+			}
+			if r.Header.Get("Authorization") != "" {
+				lockout.Fail(ip, "")
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		})
+	}
+}
@@ -1,25 +1,167 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strconv"
+	"strings"
 
-	"golang.org/x/crypto/bcrypt"
-	"phpservermanager/internal/config"
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/ratelimit"
 )
 
-// Auth provides authentication middleware.
-func Auth(cfg config.Auth) func(http.Handler) http.Handler {
+// Authenticator checks the credentials of API users
+type Authenticator interface {
+	// Authenticate checks a username and password
+	Authenticate(username, password string) (*auth.User, bool)
+	// AuthenticateToken checks an API token
+	AuthenticateToken(token string) (*auth.User, bool)
+	// AuthenticateSession checks a session ID, returning its CSRF token
+	AuthenticateSession(id string) (*auth.User, string, bool)
+	// AuthRequired reports whether any users exist
+	AuthRequired() bool
+}
+
+// anonymous is the user of requests when no users are configured
+var anonymous = &auth.User{Username: "anonymous", Role: auth.RoleAdmin}
+
+// Auth provides authentication middleware, accepting a session cookie, an
+// API token as a bearer token, or Basic auth. Requests authenticated by a
+// session must send its CSRF token unless they are safe (GET, HEAD,
+// OPTIONS). Failed passwords and tokens count towards the lockout of the
+// client and username. The authenticated user is stored in the request
+// context for the handlers' permission checks.
+func Auth(authn Authenticator, lockout *ratelimit.Lockout) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authn.AuthRequired() {
+				next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), anonymous)))
+				return
+			}
+
+			ip := auth.ClientIP(r.Context())
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				if wait, ok := lockout.Check(ip, ""); !ok {
+					ratelimit.Reject(w, wait)
+					return
+				}
+				user, ok := authn.AuthenticateToken(token)
+				if !ok {
+					lockout.Fail(ip, "")
+					unauthorized(w, r)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), user)))
+				return
+			}
+
+			if username, pass, ok := r.BasicAuth(); ok {
+				if wait, ok := lockout.Check(ip, username); !ok {
+					ratelimit.Reject(w, wait)
+					return
+				}
+				user, ok := authn.Authenticate(username, pass)
+				// Basic auth can't carry a second factor, so for users who
+				// have one it fails the same way a wrong password does
+				if !ok || user.TOTPEnabled {
+					lockout.Fail(ip, username)
+					unauthorized(w, r)
+					return
+				}
+				lockout.Succeed(username)
+				next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), user)))
+				return
+			}
+
+			if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+				user, csrfToken, ok := authn.AuthenticateSession(cookie.Value)
+				if !ok {
+					unauthorized(w, r)
+					return
+				}
+				if !safeMethod(r.Method) && subtle.ConstantTimeCompare([]byte(r.Header.Get(auth.CSRFHeader)), []byte(csrfToken)) != 1 {
+					http.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+					return
+				}
+				ctx := auth.NewSessionContext(auth.NewContext(r.Context(), user), csrfToken)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			unauthorized(w, r)
+		})
+	}
+}
+
+// RateLimit limits the requests of each client IP, responding with 429
+// Too Many Requests once it runs out. A nil limiter allows everything.
+func RateLimit(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if cfg.Username == "" || cfg.PasswordHash == "" {
-				next.ServeHTTP(w, r)
+			if wait, ok := limiter.Allow(auth.ClientIP(r.Context())); !ok {
+				ratelimit.Reject(w, wait)
 				return
 			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HSTS tells browsers to only use HTTPS for the manager for maxAge seconds
+func HSTS(maxAge int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		value := "max-age=" + strconv.Itoa(maxAge)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// unauthorized rejects a request. The Basic auth challenge is left out for
+// the web UI, which sends X-Requested-With, so that browsers show its login
+// form instead of their own prompt.
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Requested-With") == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// CORS allows browsers on the given origins to call the API with
+// credentials. Requests from other origins get no CORS headers, so browsers
+// block them. An origin of "*" allows any origin, but without credentials.
+func CORS(allowedOrigins []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		allowed[strings.TrimRight(o, "/")] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+
+			if origin != "" && (allowed[origin] || allowed["*"]) {
+				if allowed[origin] {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				}
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, "+auth.CSRFHeader)
+			}
 
-			user, pass, ok := r.BasicAuth()
-			if !ok || user != cfg.Username || bcrypt.CompareHashAndPassword([]byte(cfg.PasswordHash), []byte(pass)) != nil {
-				w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
 				return
 			}
 
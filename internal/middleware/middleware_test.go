@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/config"
+	"phpservermanager/internal/ratelimit"
+)
+
+// testAuthenticator accepts the password "secret" for every user in users
+type testAuthenticator struct {
+	users map[string]*auth.User
+}
+
+func (a testAuthenticator) Authenticate(username, password string) (*auth.User, bool) {
+	u, ok := a.users[username]
+	return u, ok && password == "secret"
+}
+
+func (a testAuthenticator) AuthenticateToken(token string) (*auth.User, bool) {
+	return nil, false
+}
+
+func (a testAuthenticator) AuthenticateSession(id string) (*auth.User, string, bool) {
+	return nil, "", false
+}
+
+func (a testAuthenticator) AuthRequired() bool { return true }
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func basicRequest(username, password string) *http.Request {
+	r := httptest.NewRequest("GET", "/api/servers", nil)
+	r.SetBasicAuth(username, password)
+	return r.WithContext(auth.NewClientIPContext(r.Context(), "192.0.2.1"))
+}
+
+func TestAuthBasic(t *testing.T) {
+	authn := testAuthenticator{users: map[string]*auth.User{
+		"alice": {Username: "alice", Role: auth.RoleAdmin},
+		"bob":   {Username: "bob", Role: auth.RoleAdmin, TOTPEnabled: true},
+	}}
+	handler := Auth(authn, ratelimit.NewLockout(100, 3, time.Minute))(okHandler)
+
+	serve := func(username, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, basicRequest(username, password))
+		return w
+	}
+
+	if w := serve("alice", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("valid credentials: got %d", w.Code)
+	}
+
+	// A user with a second factor gets the same answer for the right
+	// password as for a wrong one, so Basic auth can't be used to guess it
+	wrong := serve("bob", "wrong")
+	right := serve("bob", "secret")
+	if wrong.Code != http.StatusUnauthorized || right.Code != wrong.Code || right.Body.String() != wrong.Body.String() {
+		t.Errorf("TOTP user: wrong password got %d %q, right password got %d %q",
+			wrong.Code, wrong.Body.String(), right.Code, right.Body.String())
+	}
+
+	// Both attempts counted, so the third failure locks the user out
+	serve("bob", "secret")
+	if w := serve("bob", "secret"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("after three failures: got %d, want 429", w.Code)
+	}
+	if w := serve("alice", "secret"); w.Code != http.StatusOK {
+		t.Errorf("other user after lockout: got %d", w.Code)
+	}
+}
+
+func TestMetricsAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.MetricsConfig{Enabled: true, Username: "prometheus", PasswordHash: string(hash)}
+	handler := MetricsAuth(cfg, ratelimit.NewLockout(2, 100, time.Minute))(okHandler)
+
+	serve := func(username, password string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, basicRequest(username, password))
+		return w.Code
+	}
+
+	// The second request is answered from the cache of verified passwords
+	for i := 0; i < 2; i++ {
+		if code := serve("prometheus", "secret"); code != http.StatusOK {
+			t.Fatalf("valid credentials, request %d: got %d", i+1, code)
+		}
+	}
+	if code := serve("prometheus", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d", code)
+	}
+	if code := serve("scraper", "secret"); code != http.StatusUnauthorized {
+		t.Errorf("wrong username: got %d", code)
+	}
+	if code := serve("prometheus", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("after two failures: got %d, want 429", code)
+	}
+}
@@ -0,0 +1,242 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is the leeway allowed when checking token times
+const clockSkew = 2 * time.Minute
+
+// keyRefreshInterval limits how often the key set is fetched again for
+// tokens signed with an unknown key
+const keyRefreshInterval = time.Minute
+
+// jsonWebKey is a public key from the provider's JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keySet caches the provider's signing keys, fetching them again when a
+// token names a key it doesn't know, as happens after key rotation
+type keySet struct {
+	uri     string
+	getJSON func(ctx context.Context, url string, v interface{}) error
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newKeySet(uri string, getJSON func(context.Context, string, interface{}) error) *keySet {
+	return &keySet{uri: uri, getJSON: getJSON}
+}
+
+// key returns the key with the given ID. Tokens without a key ID can be
+// verified when the provider has a single key.
+func (ks *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if k, ok := ks.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(ks.fetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := ks.getJSON(ctx, ks.uri, &doc); err != nil {
+		return nil, fmt.Errorf("fetching signing keys: %w", err)
+	}
+	ks.fetched = time.Now()
+	ks.keys = make(map[string]crypto.PublicKey)
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if k, err := jwk.publicKey(); err == nil {
+			ks.keys[jwk.Kid] = k
+		}
+	}
+
+	if k, ok := ks.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds a cached key. It must be called with ks.mu held.
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true
+		}
+	}
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+// publicKey decodes an RSA or P-256 key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyIDToken checks the signature of an ID token and that it was issued
+// by the provider for this client and login, returning its claims. Only
+// RS256 and ES256 signatures are accepted.
+func (c *Client) verifyIDToken(ctx context.Context, raw, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token is not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("id_token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("id_token signature: %w", err)
+	}
+
+	c.mu.Lock()
+	keys := c.keys
+	c.mu.Unlock()
+	key, err := keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("id_token: %w", err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return nil, errors.New("id_token signature is invalid")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return nil, errors.New("id_token signature is invalid")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, errors.New("id_token signature is invalid")
+		}
+	default:
+		return nil, fmt.Errorf("id_token algorithm %q is not supported", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("id_token claims: %w", err)
+	}
+	if err := c.validateClaims(claims, nonce, time.Now()); err != nil {
+		return nil, fmt.Errorf("id_token: %w", err)
+	}
+	return claims, nil
+}
+
+// validateClaims checks the issuer, audience, lifetime and nonce of an ID
+// token
+func (c *Client) validateClaims(claims map[string]interface{}, nonce string, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != c.cfg.Issuer {
+		return fmt.Errorf("issuer %q doesn't match", iss)
+	}
+
+	audiences := claimStrings(claims["aud"])
+	found := false
+	for _, aud := range audiences {
+		if aud == c.cfg.ClientID {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("token was not issued for this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != c.cfg.ClientID {
+		return fmt.Errorf("authorized party %q doesn't match", azp)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return errors.New("token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(clockSkew)) {
+		return errors.New("token was issued in the future")
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return errors.New("nonce doesn't match")
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,327 @@
+// Package oidc implements the OpenID Connect authorization code flow with
+// PKCE, used to sign in to the manager through an identity provider.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/config"
+)
+
+// Defaults for the claims a user is read from
+const (
+	DefaultUsernameClaim = "preferred_username"
+	DefaultGroupsClaim   = "groups"
+)
+
+// loginTimeout is how long users have to sign in at the provider
+const loginTimeout = 10 * time.Minute
+
+// ErrUnknownState is returned for callbacks that don't belong to a login
+// started by this manager, or whose login timed out
+var ErrUnknownState = errors.New("unknown or expired login state")
+
+// roleRank orders roles from least to most privileged, so that the best
+// role mapped from a user's claims wins
+var roleRank = map[string]int{
+	auth.RoleMember:   1,
+	auth.RoleViewer:   2,
+	auth.RoleOperator: 3,
+	auth.RoleAdmin:    4,
+}
+
+// providerMetadata is the part of the discovery document the manager uses
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// pendingLogin is a login waiting for the provider's callback
+type pendingLogin struct {
+	nonce       string
+	verifier    string
+	redirectURL string
+	expires     time.Time
+}
+
+// Identity is a user as described by the provider, mapped onto the
+// manager's roles
+type Identity struct {
+	Username string
+	Role     string
+	Groups   []string
+}
+
+// Client signs users in through an OpenID Connect provider. The provider
+// is discovered on first use, so the manager starts even when it is down.
+type Client struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *providerMetadata
+	keys     *keySet
+	pending  map[string]*pendingLogin
+}
+
+// NewClient creates a client for the configured provider
+func NewClient(cfg config.OIDCConfig) (*Client, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("oidc: issuer and client_id are required")
+	}
+	if cfg.DefaultRole != "" && !auth.ValidRole(cfg.DefaultRole) {
+		return nil, fmt.Errorf("oidc: unknown default_role %q", cfg.DefaultRole)
+	}
+	for value, role := range cfg.RoleMapping {
+		if !auth.ValidRole(role) {
+			return nil, fmt.Errorf("oidc: unknown role %q mapped from %q", role, value)
+		}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = DefaultUsernameClaim
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = DefaultGroupsClaim
+	}
+
+	return &Client{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 15 * time.Second},
+		pending: make(map[string]*pendingLogin),
+	}, nil
+}
+
+// discover fetches the provider's discovery document once
+func (c *Client) discover(ctx context.Context) (*providerMetadata, error) {
+	c.mu.Lock()
+	md := c.metadata
+	c.mu.Unlock()
+	if md != nil {
+		return md, nil
+	}
+
+	md = &providerMetadata{}
+	if err := c.getJSON(ctx, c.cfg.Issuer+"/.well-known/openid-configuration", md); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != c.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q doesn't match %q", md.Issuer, c.cfg.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: provider metadata is incomplete")
+	}
+
+	c.mu.Lock()
+	c.metadata = md
+	c.keys = newKeySet(md.JWKSURI, c.getJSON)
+	c.mu.Unlock()
+	return md, nil
+}
+
+// AuthCodeURL starts a login, returning the state that identifies it and
+// the provider URL to send the user to. redirectURL is the callback the
+// provider returns to, used unless one is configured.
+func (c *Client) AuthCodeURL(ctx context.Context, redirectURL string) (string, string, error) {
+	md, err := c.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if c.cfg.RedirectURL != "" {
+		redirectURL = c.cfg.RedirectURL
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	scopes := append([]string{"openid"}, c.cfg.Scopes...)
+	if len(c.cfg.Scopes) == 0 {
+		scopes = append(scopes, "profile", "email")
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	for s, p := range c.pending {
+		if now.After(p.expires) {
+			delete(c.pending, s)
+		}
+	}
+	c.pending[state] = &pendingLogin{nonce: nonce, verifier: verifier, redirectURL: redirectURL, expires: now.Add(loginTimeout)}
+	c.mu.Unlock()
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return state, md.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange finishes the login identified by state, redeeming the
+// authorization code and validating the ID token the provider returns
+func (c *Client) Exchange(ctx context.Context, state, code string) (*Identity, error) {
+	c.mu.Lock()
+	p, exists := c.pending[state]
+	delete(c.pending, state)
+	c.mu.Unlock()
+	if !exists || time.Now().After(p.expires) {
+		return nil, ErrUnknownState
+	}
+
+	md, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {p.verifier},
+	}
+	if c.cfg.ClientSecret == "" {
+		form.Set("client_id", c.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token request: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("oidc token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc token response has no id_token")
+	}
+
+	claims, err := c.verifyIDToken(ctx, tokens.IDToken, p.nonce)
+	if err != nil {
+		return nil, err
+	}
+	return c.identity(claims)
+}
+
+// identity maps ID token claims onto a manager user. Values of the groups
+// claim become the user's groups, and the most privileged role they map to
+// becomes the user's role.
+func (c *Client) identity(claims map[string]interface{}) (*Identity, error) {
+	username, _ := claims[c.cfg.UsernameClaim].(string)
+	if !auth.ValidUsername(username) {
+		return nil, fmt.Errorf("oidc: claim %q is not a valid username: %q", c.cfg.UsernameClaim, username)
+	}
+
+	id := &Identity{Username: username, Role: c.cfg.DefaultRole}
+	for _, g := range claimStrings(claims[c.cfg.GroupsClaim]) {
+		if auth.ValidUsername(g) {
+			id.Groups = append(id.Groups, g)
+		}
+		if role, ok := c.cfg.RoleMapping[g]; ok && roleRank[role] > roleRank[id.Role] {
+			id.Role = role
+		}
+	}
+	if id.Role == "" {
+		return nil, fmt.Errorf("oidc: user %q has no role mapped from claim %q", username, c.cfg.GroupsClaim)
+	}
+	return id, nil
+}
+
+// claimStrings returns the values of a claim holding a string or a list
+// of strings
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// getJSON fetches a JSON document from the provider
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
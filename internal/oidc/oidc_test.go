@@ -0,0 +1,419 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"phpservermanager/internal/auth"
+	"phpservermanager/internal/config"
+)
+
+const (
+	testClientID     = "manager"
+	testClientSecret = "s3cret"
+	testCode         = "auth-code"
+)
+
+// mockProvider is a minimal OpenID Connect provider. It hands out an ID
+// token for testCode once the PKCE verifier matches the challenge of the
+// login, built by the token function of the test case.
+type mockProvider struct {
+	*httptest.Server
+	t      *testing.T
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	challenge string
+	nonce     string
+	token     func(p *mockProvider, nonce string) string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &mockProvider{t: t, rsaKey: rsaKey, ecKey: ecKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.tokenEndpoint)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	})
+}
+
+func (p *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	enc := base64.RawURLEncoding.EncodeToString
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": enc(p.rsaKey.N.Bytes()), "e": enc([]byte{1, 0, 1})},
+		{"kty": "EC", "kid": "ec", "use": "sig", "crv": "P-256", "x": enc(p.ecKey.X.FillBytes(make([]byte, 32))), "y": enc(p.ecKey.Y.FillBytes(make([]byte, 32)))},
+	}})
+}
+
+func (p *mockProvider) tokenEndpoint(w http.ResponseWriter, r *http.Request) {
+	if id, secret, ok := r.BasicAuth(); !ok || id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	r.ParseForm()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != testCode ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": p.token(p, p.nonce)})
+}
+
+// sign builds a JWT with the given header and claims, signed with the
+// provider's key for alg
+func (p *mockProvider) sign(header, claims map[string]interface{}) string {
+	seg := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := seg(header) + "." + seg(claims)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch header["alg"] {
+	case "RS256":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, p.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			p.t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, p.ecKey, digest[:])
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// claims returns valid ID token claims for the login with nonce
+func (p *mockProvider) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                p.URL,
+		"sub":                "1234",
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"ops", "devs"},
+	}
+}
+
+// login runs the code flow against p up to the token exchange
+func login(t *testing.T, p *mockProvider) (*Identity, error) {
+	t.Helper()
+	c, err := NewClient(config.OIDCConfig{
+		Issuer:       p.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RoleMapping:  map[string]string{"ops": auth.RoleOperator, "devs": auth.RoleViewer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	state, authURL, err := c.AuthCodeURL(ctx, "https://manager.example/api/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.HasPrefix(authURL, p.URL+"/authorize?") || q.Get("state") != state || q.Get("client_id") != testClientID {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("authorization URL lacks PKCE or nonce: %s", authURL)
+	}
+	p.challenge = q.Get("code_challenge")
+	p.nonce = q.Get("nonce")
+
+	return c.Exchange(ctx, state, testCode)
+}
+
+func TestCodeFlow(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   func(p *mockProvider, nonce string) string
+		wantErr string
+	}{
+		{
+			name: "RS256",
+			token: func(p *mockProvider, nonce string) string {
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, p.claims(nonce))
+			},
+		},
+		{
+			name: "ES256",
+			token: func(p *mockProvider, nonce string) string {
+				return p.sign(map[string]interface{}{"alg": "ES256", "kid": "ec"}, p.claims(nonce))
+			},
+		},
+		{
+			name: "audience list",
+			token: func(p *mockProvider, nonce string) string {
+				c := p.claims(nonce)
+				c["aud"] = []string{"other", testClientID}
+				c["azp"] = testClientID
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, c)
+			},
+		},
+		{
+			name: "wrong audience",
+			token: func(p *mockProvider, nonce string) string {
+				c := p.claims(nonce)
+				c["aud"] = "other"
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, c)
+			},
+			wantErr: "not issued for this client",
+		},
+		{
+			name: "wrong authorized party",
+			token: func(p *mockProvider, nonce string) string {
+				c := p.claims(nonce)
+				c["aud"] = []string{"other", testClientID}
+				c["azp"] = "other"
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, c)
+			},
+			wantErr: "authorized party",
+		},
+		{
+			name: "wrong issuer",
+			token: func(p *mockProvider, nonce string) string {
+				c := p.claims(nonce)
+				c["iss"] = "https://evil.example"
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, c)
+			},
+			wantErr: "issuer",
+		},
+		{
+			name: "expired",
+			token: func(p *mockProvider, nonce string) string {
+				c := p.claims(nonce)
+				c["exp"] = time.Now().Add(-time.Hour).Unix()
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, c)
+			},
+			wantErr: "expired",
+		},
+		{
+			name: "issued in the future",
+			token: func(p *mockProvider, nonce string) string {
+				c := p.claims(nonce)
+				c["iat"] = time.Now().Add(time.Hour).Unix()
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, c)
+			},
+			wantErr: "future",
+		},
+		{
+			name: "wrong nonce",
+			token: func(p *mockProvider, nonce string) string {
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, p.claims("replayed"))
+			},
+			wantErr: "nonce",
+		},
+		{
+			name: "bad signature",
+			token: func(p *mockProvider, nonce string) string {
+				signed := p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, p.claims(nonce))
+				c := p.claims(nonce)
+				c["preferred_username"] = "admin"
+				forged := p.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, c)
+				parts, forgedParts := strings.Split(signed, "."), strings.Split(forged, ".")
+				return parts[0] + "." + forgedParts[1] + "." + parts[2]
+			},
+			wantErr: "signature is invalid",
+		},
+		{
+			name: "RS256 header with EC key",
+			token: func(p *mockProvider, nonce string) string {
+				signed := p.sign(map[string]interface{}{"alg": "ES256", "kid": "ec"}, p.claims(nonce))
+				parts := strings.Split(signed, ".")
+				header := p.sign(map[string]interface{}{"alg": "RS256", "kid": "ec"}, nil)
+				return strings.Split(header, ".")[0] + "." + parts[1] + "." + parts[2]
+			},
+			wantErr: "signature is invalid",
+		},
+		{
+			name: "alg none",
+			token: func(p *mockProvider, nonce string) string {
+				return p.sign(map[string]interface{}{"alg": "none", "kid": "rsa"}, p.claims(nonce))
+			},
+			wantErr: "not supported",
+		},
+		{
+			name: "unknown key",
+			token: func(p *mockProvider, nonce string) string {
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "rotated"}, p.claims(nonce))
+			},
+			wantErr: "unknown signing key",
+		},
+		{
+			name: "not a JWT",
+			token: func(p *mockProvider, nonce string) string {
+				return "opaque"
+			},
+			wantErr: "not a JWT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProvider(t)
+			p.token = tt.token
+
+			id, err := login(t, p)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Username != "alice" || id.Role != auth.RoleOperator || len(id.Groups) != 2 {
+				t.Errorf("got identity %+v", id)
+			}
+		})
+	}
+}
+
+func TestExchangeUnknownState(t *testing.T) {
+	p := newMockProvider(t)
+	c, err := NewClient(config.OIDCConfig{Issuer: p.URL, ClientID: testClientID, DefaultRole: auth.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, _, err := c.AuthCodeURL(context.Background(), "https://manager.example/api/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Exchange(context.Background(), "forged", testCode); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("forged state: got %v, want ErrUnknownState", err)
+	}
+	// A state can only be redeemed once, even when the exchange failed
+	c.Exchange(context.Background(), state, "wrong-code")
+	if _, err := c.Exchange(context.Background(), state, testCode); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("reused state: got %v, want ErrUnknownState", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	p := newMockProvider(t)
+	c, err := NewClient(config.OIDCConfig{Issuer: p.URL + "/realm", ClientID: testClientID, DefaultRole: auth.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.AuthCodeURL(context.Background(), "https://manager.example/cb"); err == nil {
+		t.Error("discovery accepted a document for another issuer")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.OIDCConfig
+		claims   map[string]interface{}
+		wantRole string
+		wantErr  bool
+	}{
+		{
+			name:     "highest mapped role wins",
+			cfg:      config.OIDCConfig{RoleMapping: map[string]string{"a": auth.RoleViewer, "b": auth.RoleAdmin}},
+			claims:   map[string]interface{}{"preferred_username": "bob", "groups": []interface{}{"a", "b"}},
+			wantRole: auth.RoleAdmin,
+		},
+		{
+			name:     "default role",
+			cfg:      config.OIDCConfig{DefaultRole: auth.RoleMember},
+			claims:   map[string]interface{}{"preferred_username": "bob"},
+			wantRole: auth.RoleMember,
+		},
+		{
+			name:     "custom claims",
+			cfg:      config.OIDCConfig{UsernameClaim: "email", GroupsClaim: "roles", RoleMapping: map[string]string{"ops": auth.RoleOperator}},
+			claims:   map[string]interface{}{"email": "bob@example.com", "roles": "ops"},
+			wantRole: auth.RoleOperator,
+		},
+		{
+			name:    "no role",
+			cfg:     config.OIDCConfig{RoleMapping: map[string]string{"a": auth.RoleViewer}},
+			claims:  map[string]interface{}{"preferred_username": "bob", "groups": []interface{}{"c"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid username",
+			cfg:     config.OIDCConfig{DefaultRole: auth.RoleViewer},
+			claims:  map[string]interface{}{"preferred_username": "../bob"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Issuer, tt.cfg.ClientID = "https://idp.example", testClientID
+			c, err := NewClient(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := c.identity(tt.claims)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got identity %+v, want error", id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Role != tt.wantRole {
+				t.Errorf("got role %q, want %q", id.Role, tt.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// failureWindow is how long failed logins are remembered after the last
+// failure or lockout
+const failureWindow = 15 * time.Minute
+
+// maxLockout caps the exponential backoff of repeated failures
+const maxLockout = time.Hour
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// Lockout tracks failed logins per client IP and per username. Once either
+// reaches its limit, further attempts are refused for a lockout period that
+// doubles with every additional failure.
+type Lockout struct {
+	maxPerIP   int
+	maxPerUser int
+	base       time.Duration
+
+	mu      sync.Mutex
+	records map[string]*failures
+	cleaned time.Time
+}
+
+// NewLockout creates a tracker locking out an IP after maxPerIP and a
+// username after maxPerUser failed logins, for base at first
+func NewLockout(maxPerIP, maxPerUser int, base time.Duration) *Lockout {
+	if maxPerIP <= 0 {
+		maxPerIP = DefaultMaxFailuresPerIP
+	}
+	if maxPerUser <= 0 {
+		maxPerUser = DefaultMaxFailuresPerUser
+	}
+	if base <= 0 {
+		base = DefaultLockout
+	}
+	return &Lockout{
+		maxPerIP:   maxPerIP,
+		maxPerUser: maxPerUser,
+		base:       base,
+		records:    make(map[string]*failures),
+		cleaned:    time.Now(),
+	}
+}
+
+// Check reports whether a login from ip for username may be attempted,
+// and if not, how long until it may. An empty username only checks the IP.
+func (l *Lockout) Check(ip, username string) (time.Duration, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys(ip, username) {
+		if f, ok := l.records[key]; ok && f.lockedUntil.After(now) && f.lockedUntil.Sub(now) > wait {
+			wait = f.lockedUntil.Sub(now)
+		}
+	}
+	return wait, wait == 0
+}
+
+// Fail records a failed login from ip for username
+func (l *Lockout) Fail(ip, username string) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.cleaned) >= cleanupInterval {
+		l.cleanup(now)
+	}
+
+	for _, key := range keys(ip, username) {
+		f, ok := l.records[key]
+		if !ok || f.expired(now) {
+			f = &failures{}
+			l.records[key] = f
+		}
+		f.count++
+		f.last = now
+
+		max := l.maxPerIP
+		if key[0] == 'u' {
+			max = l.maxPerUser
+		}
+		if f.count >= max {
+			lockout := l.base << uint(min(f.count-max, 16))
+			if lockout > maxLockout || lockout <= 0 {
+				lockout = maxLockout
+			}
+			f.lockedUntil = now.Add(lockout)
+		}
+	}
+}
+
+// Succeed forgets the failed logins of username after a successful login.
+// Failures of the IP are kept, so that one valid account can't be used to
+// reset the lockout of an IP guessing others.
+func (l *Lockout) Succeed(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.records, "u:"+username)
+}
+
+// cleanup drops records whose failures and lockout expired. It must be
+// called with l.mu held.
+func (l *Lockout) cleanup(now time.Time) {
+	for key, f := range l.records {
+		if f.expired(now) {
+			delete(l.records, key)
+		}
+	}
+	l.cleaned = now
+}
+
+// expired reports whether the failures are old enough to be forgotten
+func (f *failures) expired(now time.Time) bool {
+	since := f.last
+	if f.lockedUntil.After(since) {
+		since = f.lockedUntil
+	}
+	return now.Sub(since) >= failureWindow
+}
+
+func keys(ip, username string) []string {
+	k := []string{"i:" + ip}
+	if username != "" {
+		k = append(k, "u:"+username)
+	}
+	return k
+}
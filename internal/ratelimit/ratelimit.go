@@ -0,0 +1,99 @@
+// Package ratelimit throttles API requests per client and locks out
+// clients and usernames after repeated failed logins.
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults used for zero settings
+const (
+	DefaultRate               = 20
+	DefaultBurst              = 100
+	DefaultMaxFailuresPerIP   = 20
+	DefaultMaxFailuresPerUser = 5
+	DefaultLockout            = time.Minute
+)
+
+// cleanupInterval is how often idle buckets and expired failure records
+// are dropped
+const cleanupInterval = 5 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket rate limiter keyed by client
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	cleaned time.Time
+}
+
+// NewLimiter creates a limiter allowing rate requests per second per key,
+// with bursts of up to burst requests
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), cleaned: time.Now()}
+}
+
+// Allow takes a token from the bucket of key. When it is empty, it returns
+// false and how long until the next token.
+func (l *Limiter) Allow(key string) (time.Duration, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.cleaned) >= cleanupInterval {
+		l.cleanup(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// cleanup drops buckets that have refilled. It must be called with l.mu
+// held.
+func (l *Limiter) cleanup(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.cleaned = now
+}
+
+// Reject responds with 429 Too Many Requests, telling the client when to
+// retry
+func Reject(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
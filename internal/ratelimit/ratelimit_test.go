@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    int
+		requests int
+		allowed  int
+	}{
+		{"within burst", 1, 5, 3, 3},
+		{"exactly burst", 1, 5, 5, 5},
+		{"beyond burst", 1, 5, 8, 5},
+		{"burst of one", 1, 1, 3, 1},
+		{"defaults", 0, 0, DefaultBurst + 10, DefaultBurst},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.rate, tt.burst)
+			allowed := 0
+			var wait time.Duration
+			for i := 0; i < tt.requests; i++ {
+				w, ok := l.Allow("client")
+				if ok {
+					allowed++
+				} else {
+					wait = w
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d requests, want %d", allowed, tt.requests, tt.allowed)
+			}
+			if allowed < tt.requests && (wait <= 0 || wait > time.Duration(float64(time.Second)/l.rate)) {
+				t.Errorf("retry after %v, want at most one token interval", wait)
+			}
+		})
+	}
+}
+
+func TestLimiterKeysAndRefill(t *testing.T) {
+	l := NewLimiter(100, 1)
+	if _, ok := l.Allow("a"); !ok {
+		t.Fatal("first request of a was refused")
+	}
+	if _, ok := l.Allow("a"); ok {
+		t.Fatal("second request of a was allowed")
+	}
+	if _, ok := l.Allow("b"); !ok {
+		t.Fatal("b was throttled by the requests of a")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := l.Allow("a"); !ok {
+		t.Error("the bucket of a didn't refill")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l := NewLockout(3, 2, time.Minute)
+
+	l.Fail("10.0.0.1", "alice")
+	if _, ok := l.Check("10.0.0.1", "alice"); !ok {
+		t.Fatal("locked out after one failure")
+	}
+	l.Fail("10.0.0.1", "alice")
+	if wait, ok := l.Check("10.0.0.2", "alice"); ok || wait <= 0 || wait > time.Minute {
+		t.Fatalf("username not locked out from another IP: %v, %v", wait, ok)
+	}
+	if _, ok := l.Check("10.0.0.1", "bob"); !ok {
+		t.Fatal("IP locked out before its limit")
+	}
+
+	// Further failures double the lockout
+	l.Fail("10.0.0.2", "alice")
+	if wait, _ := l.Check("", "alice"); wait <= time.Minute {
+		t.Errorf("lockout didn't grow: %v", wait)
+	}
+
+	// The third failure from 10.0.0.1 locks out the IP for everyone
+	l.Fail("10.0.0.1", "bob")
+	if _, ok := l.Check("10.0.0.1", "carol"); ok {
+		t.Error("IP not locked out")
+	}
+
+	// A success forgets the failures of the username but not of the IP
+	l.Succeed("alice")
+	if _, ok := l.Check("10.0.0.2", "alice"); !ok {
+		t.Error("username still locked out after success")
+	}
+	if _, ok := l.Check("10.0.0.1", "alice"); ok {
+		t.Error("success reset the lockout of the IP")
+	}
+}
+
+func TestReject(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want string
+	}{
+		{0, "1"},
+		{300 * time.Millisecond, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Minute, "60"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		Reject(w, tt.wait)
+		if w.Code != 429 || w.Header().Get("Retry-After") != tt.want {
+			t.Errorf("Reject(%v) = %d, Retry-After %q, want 429, %q", tt.wait, w.Code, w.Header().Get("Retry-After"), tt.want)
+		}
+	}
+}
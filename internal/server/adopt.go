@@ -0,0 +1,111 @@
+package server
+
+import (
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Orphan policies decide what happens to server processes left running by a
+// previous manager instance
+const (
+	OrphanAdopt  = "adopt"
+	OrphanKill   = "kill"
+	OrphanIgnore = "ignore"
+)
+
+const orphanPollInterval = time.Second
+
+// ValidOrphanPolicy reports whether policy is a known orphan policy
+func ValidOrphanPolicy(policy string) bool {
+	switch policy {
+	case "", OrphanAdopt, OrphanKill, OrphanIgnore:
+		return true
+	}
+	return false
+}
+
+// FindOrphan reports whether the process recorded for s is still alive and
+// verifiably the one this manager started: it must still lead its own
+// process group and have the recorded kernel start time.
+func FindOrphan(s *Server) bool {
+	if s.Pid <= 0 || s.ProcStart == 0 {
+		return false
+	}
+	if err := syscall.Kill(-s.Pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	pgid, err := syscall.Getpgid(s.Pid)
+	if err != nil || pgid != s.Pid {
+		return false
+	}
+
+	start, err := processStartTime(s.Pid)
+	return err == nil && start == s.ProcStart
+}
+
+// Adopt takes over the orphaned process recorded for s, as found by
+// FindOrphan. The manager is not its parent, so the process group is polled
+// until it disappears and its exit code is unknown. Its output is still
+// written to the capture files it was started with, which are followed
+// again.
+func Adopt(s *Server, processes map[string]*Process, mu *sync.Mutex, logger *Logger, onExit func(ExitStatus)) {
+	logger.System("adopted running process group %d from a previous manager instance", s.Pid)
+	proc := &Process{Pid: s.Pid, Done: make(chan struct{}), limiter: adoptLimiter(s), output: tailOutput(logger)}
+
+	mu.Lock()
+	processes[s.ID] = proc
+	s.Running = true
+	s.State = StateRunning
+	mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(orphanPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := syscall.Kill(-proc.Pid, 0); err == syscall.ESRCH {
+				break
+			}
+		}
+		processExited(s, processes, mu, proc, logger, onExit, -1, "adopted process exited")
+	}()
+}
+
+// KillOrphan terminates the orphaned process group recorded for s, as found
+// by FindOrphan, using the same SIGTERM/SIGKILL sequence as Stop.
+func KillOrphan(s *Server, mu *sync.Mutex, logger *Logger) {
+	mu.Lock()
+	pid := s.Pid
+	timeout := DefaultStopTimeout
+	if s.StopTimeout > 0 {
+		timeout = time.Duration(s.StopTimeout) * time.Second
+	}
+	mu.Unlock()
+
+	logger.System("terminating process group %d left by a previous manager instance", pid)
+	syscall.Kill(-pid, syscall.SIGTERM)
+
+	exited := false
+	for deadline := time.Now().Add(timeout); !exited && time.Now().Before(deadline); {
+		if err := syscall.Kill(-pid, 0); err == syscall.ESRCH {
+			exited = true
+		} else {
+			time.Sleep(orphanPollInterval / 10)
+		}
+	}
+	if !exited {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+
+	mu.Lock()
+	s.ForgetProcess()
+	mu.Unlock()
+}
+
+// ForgetProcess clears the process recorded for s. It must be called with
+// the servers' mutex held.
+func (s *Server) ForgetProcess() {
+	s.Pid = 0
+	s.ProcStart = 0
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// captureMaxSize is the size at which a capture file is truncated once
+	// everything in it has been read
+	captureMaxSize = 1024 * 1024
+
+	capturePollInterval = 250 * time.Millisecond
+)
+
+// capturePath returns the file a server process writes one of its output
+// streams to, next to the server's log file
+func (l *Logger) capturePath(stream string) string {
+	return strings.TrimSuffix(l.path, ".log") + "." + stream
+}
+
+// openCapture creates an empty capture file for a stream of a new process.
+// The process writes to the file directly rather than to a pipe, so that
+// it keeps running when the manager exits and its output isn't lost.
+func openCapture(l *Logger, stream string) (*os.File, error) {
+	path := l.capturePath(stream)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0640)
+}
+
+// captureTail follows a capture file, copying what the process writes to
+// it into a Logger stream
+type captureTail struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// tailCapture follows the capture file of stream from its start. Files of
+// processes adopted from a previous manager instance are read from the
+// start too, so output written while no manager was running is logged,
+// along with whatever the previous instance read since the file was last
+// truncated.
+func tailCapture(l *Logger, stream string) *captureTail {
+	t := &captureTail{stop: make(chan struct{}), done: make(chan struct{})}
+	path := l.capturePath(stream)
+	w := l.Stream(stream)
+
+	go func() {
+		defer close(t.done)
+		f, err := os.Open(path)
+		if err != nil {
+			l.System("failed to read %s output: %v", stream, err)
+			return
+		}
+		defer f.Close()
+
+		ticker := time.NewTicker(capturePollInterval)
+		defer ticker.Stop()
+		for {
+			io.Copy(w, f)
+			// The process appends, so once everything has been read the
+			// file can be emptied without it noticing. Output written
+			// between the last read and the truncation is lost, which is
+			// why this only happens once the file has grown.
+			if offset, err := f.Seek(0, io.SeekCurrent); err == nil && offset >= captureMaxSize {
+				if os.Truncate(path, 0) == nil {
+					f.Seek(0, io.SeekStart)
+				}
+			}
+
+			select {
+			case <-t.stop:
+				io.Copy(w, f)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return t
+}
+
+// tailOutput follows both capture files of a process
+func tailOutput(l *Logger) []*captureTail {
+	return []*captureTail{tailCapture(l, "stdout"), tailCapture(l, "stderr")}
+}
+
+// Close reads the rest of the capture file and stops following it
+func (t *captureTail) Close() {
+	close(t.stop)
+	<-t.done
+}
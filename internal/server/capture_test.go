@@ -0,0 +1,44 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureTail(t *testing.T) {
+	l := NewLogger(filepath.Join(t.TempDir(), "1.log"), 100)
+	defer l.Close()
+
+	f, err := openCapture(l, "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tail := tailCapture(l, "stdout")
+
+	f.WriteString("first\nsec")
+	time.Sleep(2 * capturePollInterval)
+	f.WriteString("ond\n")
+
+	// Once everything has been read, a large file is emptied and the
+	// process keeps appending from its start
+	f.WriteString(strings.Repeat("x", captureMaxSize) + "\n")
+	time.Sleep(2 * capturePollInterval)
+	if info, err := os.Stat(l.capturePath("stdout")); err != nil || info.Size() != 0 {
+		t.Errorf("capture file wasn't truncated: %v", info.Size())
+	}
+	f.WriteString("third\n")
+	tail.Close()
+
+	var got []string
+	for _, line := range l.Tail(0) {
+		got = append(got, line.Text)
+	}
+	// The long line is split at maxLineLength
+	if len(got) != 19 || got[0] != "first" || got[1] != "second" || got[18] != "third" {
+		t.Errorf("got %d lines, starting with %.20q", len(got), got)
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultArgs is the command run for servers without their own args
+var DefaultArgs = []string{"frankenphp", "php-server", "--listen", "{listen_addr}", "-r", "{directory}"}
+
+// Placeholders are the names that can be used in braces in args and shell
+// commands, e.g. {port}
+var Placeholders = []string{"host", "port", "directory", "bind_host", "listen_addr"}
+
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// shellMetaChars are rejected outside of quotes by SplitCommand, since they
+// only have a meaning to a shell
+const shellMetaChars = "|&;<>()$`*?[]#~\n"
+
+// ValidateCommand checks the command settings of s: args (or shell
+// commands) must only use known placeholders, and shell mode needs a
+// command.
+func (s *Server) ValidateCommand() error {
+	if s.Shell {
+		if strings.TrimSpace(s.Command) == "" {
+			return errors.New("shell mode requires a command")
+		}
+		if err := validateTemplate(s.Command); err != nil {
+			return err
+		}
+		return validateShellTemplate(s.Command)
+	}
+
+	if len(s.Args) > 0 && s.Args[0] == "" {
+		return errors.New("the first argument must name a program")
+	}
+	for _, arg := range s.Args {
+		if err := validateTemplate(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildArgs returns the argv to execute for s with all placeholders
+// expanded. Each placeholder expands inside its own argument and is never
+// re-parsed; in shell mode the expanded values are shell quoted.
+func (s *Server) BuildArgs() ([]string, error) {
+	if err := s.ValidateCommand(); err != nil {
+		return nil, err
+	}
+
+	values := s.placeholderValues()
+	if s.Shell {
+		return []string{"/bin/bash", "-c", expand(s.Command, values, shellQuote)}, nil
+	}
+
+	args := s.Args
+	if len(args) == 0 {
+		args = DefaultArgs
+	}
+	argv := make([]string, len(args))
+	for i, arg := range args {
+		argv[i] = expand(arg, values, nil)
+	}
+	return argv, nil
+}
+
+// MigrateCommand converts a legacy command string of s into args when it
+// contains no shell syntax, and switches s to shell mode otherwise. It
+// reports whether s was changed.
+func (s *Server) MigrateCommand() bool {
+	if s.Shell || s.Command == "" || len(s.Args) > 0 {
+		return false
+	}
+	if args, err := SplitCommand(s.Command); err == nil && len(args) > 0 {
+		s.Args = args
+		s.Command = ""
+	} else {
+		s.Shell = true
+	}
+	return true
+}
+
+// SplitCommand splits a command line into arguments the way a shell would
+// for simple commands: on unquoted whitespace, honouring single quotes,
+// double quotes and backslash escapes. Anything needing a real shell, such
+// as pipes, redirects, variables or globs, is rejected.
+func SplitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case c == '\\':
+			i++
+			if i >= len(command) {
+				return nil, errors.New("trailing backslash")
+			}
+			current.WriteByte(command[i])
+			inArg = true
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			current.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				switch command[i] {
+				case '$', '`':
+					return nil, fmt.Errorf("shell expansion %q is not supported", command[i])
+				case '\\':
+					if i+1 < len(command) && strings.IndexByte("\"\\$`", command[i+1]) >= 0 {
+						i++
+					}
+				}
+				current.WriteByte(command[i])
+			}
+			if i >= len(command) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inArg = true
+		case strings.IndexByte(shellMetaChars, c) >= 0:
+			return nil, fmt.Errorf("shell syntax %q is not supported", c)
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// FormatArgs renders argv as a shell-like command line for display
+func FormatArgs(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\"+shellMetaChars) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = shellQuote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (s *Server) placeholderValues() map[string]string {
+	bindHost := formatHostForBinding(s.Host)
+	listenAddr := bindHost + ":" + s.Port
+	if s.ACMEEnabled {
+		listenAddr = "https://" + listenAddr
+	}
+	return map[string]string{
+		"host":        s.Host,
+		"port":        s.Port,
+		"directory":   s.Directory,
+		"bind_host":   bindHost,
+		"listen_addr": listenAddr,
+	}
+}
+
+func validateTemplate(template string) error {
+	for _, m := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		known := false
+		for _, name := range Placeholders {
+			if m[1] == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder %s (known: {%s})", m[0], strings.Join(Placeholders, "}, {"))
+		}
+	}
+	return nil
+}
+
+// validateShellTemplate makes sure every placeholder of a shell command is
+// unquoted. Values are single quoted when expanded, which only keeps them
+// from being interpreted outside of other quotes: inside double quotes the
+// quotes would be literal and $(...) in a value would still run.
+func validateShellTemplate(template string) error {
+	starts := make(map[int]string)
+	for _, m := range placeholderPattern.FindAllStringIndex(template, -1) {
+		starts[m[0]] = template[m[0]:m[1]]
+	}
+	if len(starts) > 0 && strings.Contains(template, "<<") {
+		return errors.New("placeholders can't be used in shell commands with here-documents")
+	}
+
+	var quote byte
+	for i := 0; i < len(template); i++ {
+		if name, ok := starts[i]; ok && quote != 0 {
+			return fmt.Errorf("placeholder %s must not be quoted in shell mode; its value is quoted when expanded", name)
+		}
+		switch c := template[i]; {
+		case c == '\\' && quote != '\'':
+			if name, ok := starts[i+1]; ok {
+				return fmt.Errorf("placeholder %s must not be escaped in shell mode", name)
+			}
+			i++
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote != 0 && c == quote:
+			quote = 0
+		}
+	}
+	return nil
+}
+
+func expand(template string, values map[string]string, quote func(string) string) string {
+	return placeholderPattern.ReplaceAllStringFunc(template, func(m string) string {
+		v := values[m[1:len(m)-1]]
+		if quote != nil {
+			return quote(v)
+		}
+		return v
+	})
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// lookPath finds an executable in the PATH of env, which may differ from
+// the manager's own
+func lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+
+	var path string
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			path = kv[len("PATH="):]
+		}
+	}
+
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := filepath.Join(dir, file)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s: executable file not found in PATH", file)
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{command: "", want: nil},
+		{command: "php -S {listen_addr}", want: []string{"php", "-S", "{listen_addr}"}},
+		{command: "  php\t -S  x ", want: []string{"php", "-S", "x"}},
+		{command: `php -t 'my dir'`, want: []string{"php", "-t", "my dir"}},
+		{command: `php -t "my dir"`, want: []string{"php", "-t", "my dir"}},
+		{command: `php -t my\ dir`, want: []string{"php", "-t", "my dir"}},
+		{command: `echo "a \"b\" \\ c"`, want: []string{"echo", `a "b" \ c`}},
+		{command: `echo 'it''s'`, want: []string{"echo", "its"}},
+		{command: `echo "" ''`, want: []string{"echo", "", ""}},
+		{command: `echo '$(id); ` + "`id`'", want: []string{"echo", "$(id); `id`"}},
+		{command: "php -S x; rm -rf /", wantErr: true},
+		{command: "php -S x && id", wantErr: true},
+		{command: "php | tee log", wantErr: true},
+		{command: "php > log", wantErr: true},
+		{command: "php $HOME", wantErr: true},
+		{command: "php $(id)", wantErr: true},
+		{command: "php `id`", wantErr: true},
+		{command: `php "$(id)"`, wantErr: true},
+		{command: "php \"`id`\"", wantErr: true},
+		{command: "php *.php", wantErr: true},
+		{command: "php ~/x", wantErr: true},
+		{command: "php\nid", wantErr: true},
+		{command: `php 'x`, wantErr: true},
+		{command: `php "x`, wantErr: true},
+		{command: `php x\`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := SplitCommand(tt.command)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("SplitCommand(%q) = %q, want error", tt.command, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitCommand(%q) = %q, %v, want %q", tt.command, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		shell    bool
+		wantErr  bool
+	}{
+		{template: "{host}:{port}"},
+		{template: "--root={directory}"},
+		{template: "{bind_host} {listen_addr}"},
+		{template: "{user}", wantErr: true},
+		{template: "{PORT}", wantErr: true},
+		{template: "{}"},
+		{template: "php -S {listen_addr} -t {directory}", shell: true},
+		{template: "cd {directory} && exec php -S {listen_addr} 2>&1 | tee log", shell: true},
+		{template: `echo "$HOME" {directory} 'literal'`, shell: true},
+		{template: "echo $(ls {directory})", shell: true},
+		{template: `echo "{directory}"`, shell: true, wantErr: true},
+		{template: `echo "root: {directory}"`, shell: true, wantErr: true},
+		{template: `echo '{directory}'`, shell: true, wantErr: true},
+		{template: `echo $'{directory}'`, shell: true, wantErr: true},
+		{template: `echo "$(ls {directory})"`, shell: true, wantErr: true},
+		{template: `echo \{directory}`, shell: true, wantErr: true},
+		{template: "cat <<EOF\n{directory}\nEOF", shell: true, wantErr: true},
+		{template: `echo "a\"" {directory}`, shell: true},
+		{template: `echo 'a\' {directory}`, shell: true},
+		{template: "echo {nope}", shell: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		s := &Server{Args: []string{"php", tt.template}}
+		if tt.shell {
+			s = &Server{Shell: true, Command: tt.template}
+		}
+		err := s.ValidateCommand()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateCommand(%q, shell %v) = %v, want error %v", tt.template, tt.shell, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	values := map[string]string{"host": "::1", "port": "8000", "directory": "/srv/it's"}
+	tests := []struct {
+		template string
+		quote    func(string) string
+		want     string
+	}{
+		{"{host}:{port}", nil, "::1:8000"},
+		{"-t{directory}", nil, "-t/srv/it's"},
+		{"{directory}", shellQuote, `'/srv/it'\''s'`},
+		{"{port}{port}", shellQuote, "'8000''8000'"},
+		{"{unknown}", nil, ""},
+		{"no placeholders", shellQuote, "no placeholders"},
+		{"{ port }", nil, "{ port }"},
+	}
+
+	for _, tt := range tests {
+		if got := expand(tt.template, values, tt.quote); got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  Server
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			server: Server{Host: "127.0.0.1", Port: "8000", Directory: "/srv/www"},
+			want:   []string{"frankenphp", "php-server", "--listen", "127.0.0.1:8000", "-r", "/srv/www"},
+		},
+		{
+			name:   "IPv6 and ACME",
+			server: Server{Host: "::1", Port: "443", Directory: "/srv", ACMEEnabled: true},
+			want:   []string{"frankenphp", "php-server", "--listen", "https://[::1]:443", "-r", "/srv"},
+		},
+		{
+			name:   "values are never split",
+			server: Server{Host: "localhost", Port: "8000", Directory: "/srv/a b; rm -rf /", Args: []string{"php", "-S", "{host}:{port}", "-t", "{directory}"}},
+			want:   []string{"php", "-S", "localhost:8000", "-t", "/srv/a b; rm -rf /"},
+		},
+		{
+			name:   "shell",
+			server: Server{Host: "localhost", Port: "8000", Directory: "/srv/$(id)", Shell: true, Command: "cd {directory} && php -S {bind_host}:{port}"},
+			want:   []string{"/bin/bash", "-c", "cd '/srv/$(id)' && php -S 'localhost':'8000'"},
+		},
+		{
+			name:    "quoted placeholder in shell mode",
+			server:  Server{Directory: "/srv", Shell: true, Command: `php -t "{directory}"`},
+			wantErr: true,
+		},
+		{
+			name:    "unknown placeholder",
+			server:  Server{Args: []string{"php", "{nope}"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty program",
+			server:  Server{Args: []string{"", "x"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.server.BuildArgs()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want error", got)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestShellInjection runs shell commands whose placeholders expand to
+// hostile values and checks that the values are passed through verbatim
+func TestShellInjection(t *testing.T) {
+	if _, err := exec.LookPath("/bin/bash"); err != nil {
+		t.Skip("bash is not available")
+	}
+	marker := filepath.Join(t.TempDir(), "pwned")
+	for _, dir := range []string{
+		"/srv/$(touch " + marker + ")",
+		"/srv/`touch " + marker + "`",
+		"/srv/'; touch " + marker + "; '",
+		`/srv/"; touch ` + marker + `; "`,
+		"/srv/a\nb",
+	} {
+		s := Server{Host: "localhost", Port: "8000", Directory: dir, Shell: true, Command: "printf %s {directory}"}
+		argv, err := s.BuildArgs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(argv[0], argv[1:]...).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", argv, err)
+		}
+		if string(out) != dir {
+			t.Errorf("directory %q came out as %q", dir, out)
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Fatalf("directory %q ran a command", dir)
+		}
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    Server
+		changed   bool
+		wantArgs  []string
+		wantShell bool
+	}{
+		{
+			name:     "simple command",
+			server:   Server{Command: "php -S {host}:{port} -t '{directory}'"},
+			changed:  true,
+			wantArgs: []string{"php", "-S", "{host}:{port}", "-t", "{directory}"},
+		},
+		{
+			name:      "shell syntax",
+			server:    Server{Command: "cd {directory} && php -S {host}:{port}"},
+			changed:   true,
+			wantShell: true,
+		},
+		{
+			name:      "unbalanced quotes",
+			server:    Server{Command: "php 'x"},
+			changed:   true,
+			wantShell: true,
+		},
+		{
+			name:     "already has args",
+			server:   Server{Command: "ignored", Args: []string{"php"}},
+			wantArgs: []string{"php"},
+		},
+		{
+			name:      "already shell",
+			server:    Server{Command: "php | tee", Shell: true},
+			wantShell: true,
+		},
+		{
+			name:   "no command",
+			server: Server{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.server
+			if changed := s.MigrateCommand(); changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if !reflect.DeepEqual(s.Args, tt.wantArgs) || s.Shell != tt.wantShell {
+				t.Errorf("got args %q, shell %v, want %q, %v", s.Args, s.Shell, tt.wantArgs, tt.wantShell)
+			}
+			if s.Shell && s.Command != tt.server.Command {
+				t.Errorf("shell command changed to %q", s.Command)
+			}
+			if !s.Shell && len(s.Args) > 0 && tt.changed && s.Command != "" {
+				t.Errorf("command %q kept after migrating to args", s.Command)
+			}
+		})
+	}
+}
+
+func TestFormatArgs(t *testing.T) {
+	got := FormatArgs([]string{"php", "-t", "my dir", "", "it's", "$x"})
+	want := `php -t 'my dir' '' 'it'\''s' '$x'`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
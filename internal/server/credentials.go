@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// Credential resolves RunAsUser and RunAsGroup into the credential the
+// server's process is started with, together with the user it belongs to.
+// The credential is nil when the process needs no switch, and both are nil
+// when no user or group is configured.
+func (s *Server) Credential() (*syscall.Credential, *user.User, error) {
+	if s.RunAsUser == "" && s.RunAsGroup == "" {
+		return nil, nil, nil
+	}
+
+	var u *user.User
+	var err error
+	if s.RunAsUser != "" {
+		u, err = lookupUser(s.RunAsUser)
+	} else {
+		u, err = user.Current()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s has a non-numeric uid", u.Username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s has a non-numeric gid", u.Username)
+	}
+
+	if s.RunAsGroup != "" {
+		g, err := lookupGroup(s.RunAsGroup)
+		if err != nil {
+			return nil, nil, err
+		}
+		if gid, err = strconv.ParseUint(g.Gid, 10, 32); err != nil {
+			return nil, nil, fmt.Errorf("group %s has a non-numeric gid", g.Name)
+		}
+	}
+
+	var groups []uint32
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(g))
+			}
+		}
+	}
+
+	// Only root can switch credentials; anyone can "switch" to themselves
+	if os.Geteuid() != 0 {
+		if int(uid) != os.Geteuid() || int(gid) != os.Getegid() {
+			return nil, nil, fmt.Errorf("the manager must run as root to start servers as %s", u.Username)
+		}
+		return nil, u, nil
+	}
+
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}, u, nil
+}
+
+// ValidateRunAs checks that the configured user and group exist and that
+// the server's process will be able to read its Directory
+func (s *Server) ValidateRunAs() error {
+	cred, _, err := s.Credential()
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.Directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.Directory)
+	}
+
+	// Without a user switch the process reads it as the manager does
+	if cred == nil {
+		f, err := os.Open(s.Directory)
+		if err != nil {
+			return fmt.Errorf("%s is not readable: %w", s.Directory, err)
+		}
+		f.Close()
+		return nil
+	}
+	if !canReadDir(s.Directory, cred) {
+		return fmt.Errorf("%s is not readable by the configured user and group", s.Directory)
+	}
+	return nil
+}
+
+// canReadDir checks the permission bits of a directory for read and search
+// access by cred, and of its parents for search access, both along the path
+// as given and along the one its symlinks resolve to. ACLs are not taken
+// into account.
+func canReadDir(dir string, cred *syscall.Credential) bool {
+	if cred.Uid == 0 {
+		return true
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return false
+	}
+	for _, path := range []string{abs, resolved} {
+		perm := os.FileMode(05)
+		for {
+			info, err := os.Stat(path)
+			if err != nil || !permits(info, cred, perm) {
+				return false
+			}
+			parent := filepath.Dir(path)
+			if parent == path {
+				break
+			}
+			path, perm = parent, 01
+		}
+	}
+	return true
+}
+
+// permits reports whether the permission bits of info grant cred the
+// access in perm, given as the bits for others (04 read, 01 search)
+func permits(info os.FileInfo, cred *syscall.Credential, perm os.FileMode) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+
+	mode := info.Mode().Perm()
+	if st.Uid == cred.Uid {
+		return mode&(perm<<6) == perm<<6
+	}
+
+	inGroup := st.Gid == cred.Gid
+	for _, g := range cred.Groups {
+		if st.Gid == g {
+			inGroup = true
+		}
+	}
+	if inGroup {
+		return mode&(perm<<3) == perm<<3
+	}
+	return mode&perm == perm
+}
+
+// lookupUser finds a user by name or numeric uid
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %q", name)
+	}
+	return u, nil
+}
+
+// lookupGroup finds a group by name or numeric gid
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if g, err := user.LookupGroupId(name); err == nil {
+			return g, nil
+		}
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown group %q", name)
+	}
+	return g, nil
+}
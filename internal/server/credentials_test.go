@@ -0,0 +1,71 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCanReadDir(t *testing.T) {
+	// The directories of t.TempDir are only searchable by the test's user
+	root := t.TempDir()
+	for _, dir := range []string{root, filepath.Dir(root)} {
+		if err := os.Chmod(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	docroot := filepath.Join(root, "site", "public")
+	if err := os.MkdirAll(docroot, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Owned by the test's user, so this is another user in its group or not
+	other := &syscall.Credential{Uid: uint32(os.Getuid()) + 1000, Gid: uint32(os.Getgid()) + 1000}
+	group := &syscall.Credential{Uid: uint32(os.Getuid()) + 1000, Gid: uint32(os.Getgid())}
+
+	tests := []struct {
+		name       string
+		siteMode   os.FileMode
+		publicMode os.FileMode
+		cred       *syscall.Credential
+		want       bool
+	}{
+		{"readable", 0755, 0755, other, true},
+		{"not readable", 0755, 0751, other, false},
+		{"not searchable", 0755, 0754, other, false},
+		{"parent not searchable", 0750, 0755, other, false},
+		{"parent searchable by group", 0710, 0755, group, true},
+		{"parent only readable by group", 0740, 0755, group, false},
+		{"root", 0700, 0700, &syscall.Credential{Uid: 0}, true},
+	}
+
+	for _, tt := range tests {
+		if err := os.Chmod(filepath.Dir(docroot), tt.siteMode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(docroot, tt.publicMode); err != nil {
+			t.Fatal(err)
+		}
+		if got := canReadDir(docroot, tt.cred); got != tt.want {
+			t.Errorf("%s: canReadDir = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	os.Chmod(filepath.Dir(docroot), 0755)
+
+	// A symlink doesn't make a directory below a private one reachable
+	private := filepath.Join(root, "private")
+	if err := os.MkdirAll(filepath.Join(private, "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(private, 0700); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "public")
+	if err := os.Symlink(filepath.Join(private, "public"), link); err != nil {
+		t.Fatal(err)
+	}
+	if canReadDir(link, other) {
+		t.Error("canReadDir followed a symlink into a directory that isn't searchable")
+	}
+}
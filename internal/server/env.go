@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvFileName is the file loaded from the document root when LoadEnvFile is set
+const EnvFileName = ".env"
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidEnvName reports whether name can be used as an environment variable
+func ValidEnvName(name string) bool {
+	return envNamePattern.MatchString(name)
+}
+
+// Environment returns the environment for the server's process. It starts
+// from the manager's environment with /usr/local/bin added to PATH and, if
+// u is not nil, HOME, USER and LOGNAME set for that user. It then applies
+// the .env file from the document root (if enabled), Env and finally
+// Secrets, later sources overriding earlier ones.
+func (s *Server) Environment(u *user.User) ([]string, error) {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	vars["PATH"] = "/usr/local/bin:" + vars["PATH"]
+	if u != nil {
+		vars["HOME"] = u.HomeDir
+		vars["USER"] = u.Username
+		vars["LOGNAME"] = u.Username
+	}
+
+	if s.LoadEnvFile {
+		fileVars, err := ParseEnvFile(filepath.Join(s.Directory, EnvFileName))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+	for k, v := range s.Env {
+		vars[k] = v
+	}
+	for k, v := range s.Secrets {
+		vars[k] = v
+	}
+
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// ParseEnvFile reads KEY=VALUE pairs from a dotenv style file. Blank lines,
+// comments and an optional "export " prefix are ignored. Values may be
+// single quoted (taken literally) or double quoted (with escape sequences).
+func ParseEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNo)
+		}
+		key := strings.TrimSpace(line[:i])
+		if !ValidEnvName(key) {
+			return nil, fmt.Errorf("%s:%d: invalid variable name %q", path, lineNo, key)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func parseEnvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+
+	switch raw[0] {
+	case '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quoted value")
+		}
+		return raw[1 : end+1], nil
+	case '"':
+		for end := 1; end < len(raw); end++ {
+			if raw[end] == '\\' {
+				end++
+				continue
+			}
+			if raw[end] == '"' {
+				return strconv.Unquote(raw[:end+1])
+			}
+		}
+		return "", fmt.Errorf("unterminated double quoted value")
+	}
+
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvValue(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "", want: ""},
+		{raw: "plain", want: "plain"},
+		{raw: "with spaces  ", want: "with spaces"},
+		{raw: "value # comment", want: "value"},
+		{raw: "a#b", want: "a#b"},
+		{raw: `'single $HOME \n'`, want: `single $HOME \n`},
+		{raw: `'a' # comment`, want: "a"},
+		{raw: `"double\n\"quoted\""`, want: "double\n\"quoted\""},
+		{raw: `"a # b"`, want: "a # b"},
+		{raw: `"tab\there"`, want: "tab\there"},
+		{raw: `'unterminated`, wantErr: true},
+		{raw: `"unterminated`, wantErr: true},
+		{raw: `"bad \q escape"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseEnvValue(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseEnvValue(%q) = %q, %v, want %q, error %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			content: strings.Join([]string{
+				"# comment",
+				"",
+				"APP_ENV=production",
+				"export DB_HOST = db.local ",
+				`DB_PASSWORD="p@ss word"`,
+				"EMPTY=",
+				"APP_ENV=override",
+			}, "\n"),
+			want: map[string]string{"APP_ENV": "override", "DB_HOST": "db.local", "DB_PASSWORD": "p@ss word", "EMPTY": ""},
+		},
+		{name: "no equals sign", content: "A=1\nNOVALUE", wantErr: ":2: expected KEY=VALUE"},
+		{name: "empty name", content: "=1", wantErr: ":1: expected KEY=VALUE"},
+		{name: "invalid name", content: "1A=1", wantErr: "invalid variable name"},
+		{name: "name with dash", content: "MY-VAR=1", wantErr: "invalid variable name"},
+		{name: "bad value", content: "A='x", wantErr: "unterminated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), EnvFileName)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseEnvFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvironmentPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, EnvFileName), []byte("A=file\nB=file\nC=file\n"), 0600)
+	s := &Server{
+		Directory:   dir,
+		LoadEnvFile: true,
+		Env:         map[string]string{"B": "env", "C": "env"},
+		Secrets:     map[string]string{"C": "secret"},
+	}
+
+	env, err := s.Environment(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		got[k] = v
+	}
+	if got["A"] != "file" || got["B"] != "env" || got["C"] != "secret" || !strings.HasPrefix(got["PATH"], "/usr/local/bin:") {
+		t.Errorf("got A=%q B=%q C=%q PATH=%q", got["A"], got["B"], got["C"], got["PATH"])
+	}
+}
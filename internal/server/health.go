@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health check types
+const (
+	HealthCheckTCP  = "tcp"
+	HealthCheckHTTP = "http"
+)
+
+// Health states
+const (
+	HealthUnknown   = "unknown"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+const (
+	defaultHealthInterval  = 10 * time.Second
+	defaultHealthTimeout   = 5 * time.Second
+	defaultHealthThreshold = 3
+	maxHealthBodyRead      = 1024 * 1024
+)
+
+// HealthCheck describes how to probe a running server
+type HealthCheck struct {
+	Type             string `json:"type"`
+	Path             string `json:"path,omitempty"`
+	ExpectedStatus   int    `json:"expected_status,omitempty"`
+	ExpectedBody     string `json:"expected_body,omitempty"`
+	Interval         int    `json:"interval"`
+	Timeout          int    `json:"timeout"`
+	FailureThreshold int    `json:"failure_threshold"`
+	RestartOnFailure bool   `json:"restart_on_failure"`
+}
+
+// Validate checks the health check settings
+func (hc *HealthCheck) Validate() error {
+	switch hc.Type {
+	case HealthCheckTCP, HealthCheckHTTP:
+	default:
+		return fmt.Errorf("health check type must be %q or %q", HealthCheckTCP, HealthCheckHTTP)
+	}
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.FailureThreshold < 0 {
+		return errors.New("health check interval, timeout and failure_threshold must not be negative")
+	}
+	if hc.ExpectedStatus != 0 && (hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599) {
+		return errors.New("health check expected_status must be a valid HTTP status code")
+	}
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		return errors.New("health check path must start with /")
+	}
+	return nil
+}
+
+// IntervalDuration returns the time between checks
+func (hc *HealthCheck) IntervalDuration() time.Duration {
+	if hc.Interval > 0 {
+		return time.Duration(hc.Interval) * time.Second
+	}
+	return defaultHealthInterval
+}
+
+// TimeoutDuration returns how long a single check may take
+func (hc *HealthCheck) TimeoutDuration() time.Duration {
+	if hc.Timeout > 0 {
+		return time.Duration(hc.Timeout) * time.Second
+	}
+	return defaultHealthTimeout
+}
+
+// Threshold returns the number of consecutive failures after which a server
+// is considered unhealthy
+func (hc *HealthCheck) Threshold() int {
+	if hc.FailureThreshold > 0 {
+		return hc.FailureThreshold
+	}
+	return defaultHealthThreshold
+}
+
+// CheckHealth probes s once using its health check, returning nil if the
+// server is healthy
+func CheckHealth(ctx context.Context, s *Server) error {
+	hc := s.HealthCheck
+	if hc == nil {
+		return errors.New("no health check configured")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hc.TimeoutDuration())
+	defer cancel()
+
+	addr := net.JoinHostPort(probeHost(s.Host), s.Port)
+	if hc.Type == HealthCheckTCP {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	scheme := "http"
+	if s.ACMEEnabled {
+		scheme = "https"
+	}
+	path := hc.Path
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+addr+path, nil)
+	if err != nil {
+		return err
+	}
+	if len(s.ACMEDomains) > 0 {
+		req.Host = s.ACMEDomains[0]
+	}
+
+	// The probe connects by IP, so certificates can't be verified against it
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	expected := hc.ExpectedStatus
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	if resp.StatusCode != expected {
+		return fmt.Errorf("unexpected status %d, expected %d", resp.StatusCode, expected)
+	}
+
+	if hc.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodyRead))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), hc.ExpectedBody) {
+			return fmt.Errorf("response body does not contain %q", hc.ExpectedBody)
+		}
+	}
+
+	return nil
+}
+
+// probeHost returns the address to connect to for a server bound to host,
+// mapping wildcard addresses to loopback
+func probeHost(host string) string {
+	switch strings.Trim(host, "[]") {
+	case "", "0.0.0.0":
+		return "127.0.0.1"
+	case "::":
+		return "::1"
+	}
+	return strings.Trim(host, "[]")
+}
@@ -0,0 +1,51 @@
+package server
+
+import "errors"
+
+// How resource limits are enforced for a process
+const (
+	EnforcementCgroup = "cgroup"
+	EnforcementRlimit = "rlimit"
+	EnforcementNone   = "none"
+)
+
+// CgroupRoot is the cgroup v2 directory under which each limited server
+// gets its own cgroup
+var CgroupRoot = "/sys/fs/cgroup/phpservermanager"
+
+// Limits are resource limits applied to a server's processes. Zero values
+// mean unlimited. CPUPercent is relative to one CPU, so 200 allows two full
+// CPUs.
+type Limits struct {
+	MemoryMB   int `json:"memory_mb,omitempty"`
+	CPUPercent int `json:"cpu_percent,omitempty"`
+	Pids       int `json:"pids,omitempty"`
+	NoFile     int `json:"nofile,omitempty"`
+}
+
+// Validate checks the limits
+func (l *Limits) Validate() error {
+	if l.MemoryMB < 0 || l.CPUPercent < 0 || l.Pids < 0 || l.NoFile < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+func (l *Limits) empty() bool {
+	return l == nil || *l == Limits{}
+}
+
+// ResourceUsage is the current resource usage of a limited server
+type ResourceUsage struct {
+	Enforcement    string `json:"enforcement"`
+	MemoryBytes    uint64 `json:"memory_bytes,omitempty"`
+	MemoryMaxBytes uint64 `json:"memory_max_bytes,omitempty"`
+	CPUUsageUsec   uint64 `json:"cpu_usage_usec,omitempty"`
+	Pids           uint64 `json:"pids,omitempty"`
+}
+
+// Usage returns the resource usage of the process, or nil if it runs
+// without limits
+func (p *Process) Usage() *ResourceUsage {
+	return p.limiter.usage()
+}
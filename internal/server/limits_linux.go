@@ -0,0 +1,299 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const cpuPeriodUsec = 100000
+
+// A cgroup can only be removed once the processes killed in it are gone,
+// which takes a moment
+const (
+	cgroupRemoveAttempts = 20
+	cgroupRemoveDelay    = 50 * time.Millisecond
+)
+
+// limitWrapperName is argv[0] of the manager binary when it is re-executed
+// to set rlimits before executing a server's command
+const limitWrapperName = "phpservermanager-rlimit"
+
+// limiter applies a server's Limits to its process, preferably by starting
+// it in a dedicated cgroup v2 and otherwise with setrlimit
+type limiter struct {
+	limits Limits
+	logger *Logger
+	cgroup string
+	fd     *os.File
+}
+
+// newLimiter prepares the limits of s, returning nil if it has none
+func newLimiter(s *Server, logger *Logger) *limiter {
+	if s.Limits.empty() {
+		return nil
+	}
+
+	l := &limiter{limits: *s.Limits, logger: logger}
+	path, err := setupCgroup(s.ID, l.limits)
+	if err != nil {
+		logger.System("cgroups unavailable (%v), falling back to setrlimit", err)
+		return l
+	}
+	l.cgroup = path
+	return l
+}
+
+// adoptLimiter picks up the cgroup of a process adopted from a previous
+// manager instance, if it has one
+func adoptLimiter(s *Server) *limiter {
+	path := cgroupPath(s.ID)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	return &limiter{cgroup: path}
+}
+
+// prepare makes cmd start directly inside the cgroup, and with the rlimits
+// that aren't covered by it already in place
+func (l *limiter) prepare(cmd *exec.Cmd) error {
+	if l == nil {
+		return nil
+	}
+
+	var memory uint64
+	if l.cgroup == "" && l.limits.MemoryMB > 0 {
+		memory = uint64(l.limits.MemoryMB) * 1024 * 1024
+	}
+	if l.limits.NoFile > 0 || memory > 0 {
+		wrapLimits(cmd, uint64(l.limits.NoFile), memory)
+	}
+
+	if l.cgroup == "" {
+		return nil
+	}
+	f, err := os.Open(l.cgroup)
+	if err != nil {
+		return err
+	}
+	l.fd = f
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return nil
+}
+
+// wrapLimits runs cmd through the manager binary, which sets the open file
+// and data segment limits (0 for unlimited) before executing the command.
+// The data limit covers the heap and private mappings without counting
+// shared libraries and reserved address space, which PHP and Go binaries
+// have plenty of.
+// Setting them with prlimit after the start would leave a window in which
+// the server runs unlimited, and setting them in the manager itself before
+// forking would limit the manager.
+func wrapLimits(cmd *exec.Cmd, nofile, data uint64) {
+	args := []string{limitWrapperName, strconv.FormatUint(nofile, 10), strconv.FormatUint(data, 10), cmd.Path}
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+}
+
+// RunLimitWrapper sets the rlimits passed by wrapLimits and executes the
+// server's command, if the manager was started as the wrapper. It must be
+// called at the start of main.
+func RunLimitWrapper() {
+	if os.Args[0] != limitWrapperName {
+		return
+	}
+	if len(os.Args) < 5 {
+		fmt.Fprintln(os.Stderr, "usage: "+limitWrapperName+" NOFILE DATA PATH ARGV0 [ARG...]")
+		os.Exit(127)
+	}
+
+	for _, limit := range []struct {
+		resource int
+		value    string
+	}{{syscall.RLIMIT_NOFILE, os.Args[1]}, {syscall.RLIMIT_DATA, os.Args[2]}} {
+		value, err := strconv.ParseUint(limit.value, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid limit %q\n", limit.value)
+			os.Exit(127)
+		}
+		if value == 0 {
+			continue
+		}
+		if err := syscall.Setrlimit(limit.resource, &syscall.Rlimit{Cur: value, Max: value}); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set resource limit: %v\n", err)
+			os.Exit(127)
+		}
+	}
+
+	err := syscall.Exec(os.Args[3], os.Args[4:], os.Environ())
+	fmt.Fprintf(os.Stderr, "failed to execute %s: %v\n", os.Args[3], err)
+	os.Exit(127)
+}
+
+// canFallback reports whether a failed start may be retried without cgroups
+func (l *limiter) canFallback() bool {
+	return l != nil && l.cgroup != ""
+}
+
+// fallback switches from cgroups to setrlimit, e.g. on kernels that can't
+// start processes in a cgroup
+func (l *limiter) fallback() {
+	l.closeFD()
+	removeCgroup(l.cgroup)
+	l.cgroup = ""
+}
+
+// started is called once the process runs, with its limits in place
+func (l *limiter) started(pid int) {
+	if l == nil {
+		return
+	}
+	l.closeFD()
+
+	if l.cgroup == "" && (l.limits.CPUPercent > 0 || l.limits.Pids > 0) {
+		l.logger.System("CPU and process limits are not enforced without cgroups")
+	}
+}
+
+// usage reads the current resource usage of the cgroup
+func (l *limiter) usage() *ResourceUsage {
+	if l == nil {
+		return nil
+	}
+	if l.cgroup == "" {
+		return &ResourceUsage{Enforcement: EnforcementRlimit, MemoryMaxBytes: uint64(l.limits.MemoryMB) * 1024 * 1024}
+	}
+
+	u := &ResourceUsage{Enforcement: EnforcementCgroup}
+	u.MemoryBytes, _ = readCgroupUint(l.cgroup, "memory.current")
+	u.MemoryMaxBytes, _ = readCgroupUint(l.cgroup, "memory.max")
+	u.Pids, _ = readCgroupUint(l.cgroup, "pids.current")
+	if data, err := os.ReadFile(filepath.Join(l.cgroup, "cpu.stat")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if v, ok := strings.CutPrefix(line, "usage_usec "); ok {
+				u.CPUUsageUsec, _ = strconv.ParseUint(v, 10, 64)
+			}
+		}
+	}
+	return u
+}
+
+// cleanup removes the cgroup once the process group is gone. Workers that
+// left the process group would keep the cgroup busy, so they are killed.
+func (l *limiter) cleanup() {
+	if l == nil {
+		return
+	}
+	l.closeFD()
+	if l.cgroup == "" {
+		return
+	}
+	if err := removeCgroup(l.cgroup); err != nil && l.logger != nil {
+		l.logger.System("failed to remove cgroup: %v", err)
+	}
+}
+
+// removeCgroup kills the processes left in a cgroup and removes it
+func removeCgroup(path string) error {
+	if err := os.WriteFile(filepath.Join(path, "cgroup.kill"), []byte("1"), 0644); err != nil {
+		// cgroup.kill needs Linux 5.14
+		if data, err := os.ReadFile(filepath.Join(path, "cgroup.procs")); err == nil {
+			for _, field := range strings.Fields(string(data)) {
+				if pid, err := strconv.Atoi(field); err == nil {
+					syscall.Kill(pid, syscall.SIGKILL)
+				}
+			}
+		}
+	}
+
+	var err error
+	for i := 0; i < cgroupRemoveAttempts; i++ {
+		err = os.Remove(path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			return err
+		}
+		time.Sleep(cgroupRemoveDelay)
+	}
+	return err
+}
+
+func (l *limiter) closeFD() {
+	if l.fd != nil {
+		l.fd.Close()
+		l.fd = nil
+	}
+}
+
+func cgroupPath(id string) string {
+	return filepath.Join(CgroupRoot, "server-"+id)
+}
+
+// setupCgroup creates the cgroup of a server below CgroupRoot and writes
+// its limits
+func setupCgroup(id string, limits Limits) (string, error) {
+	parent := filepath.Dir(CgroupRoot)
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return "", errors.New("cgroup v2 is not mounted at " + parent)
+	}
+	if err := os.MkdirAll(CgroupRoot, 0755); err != nil {
+		return "", err
+	}
+
+	controllers := []string{"memory", "cpu", "pids"}
+	enableControllers(parent, controllers)
+	enableControllers(CgroupRoot, controllers)
+
+	path := cgroupPath(id)
+	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+
+	settings := []struct {
+		file  string
+		set   bool
+		value string
+		reset string
+	}{
+		{"memory.max", limits.MemoryMB > 0, strconv.Itoa(limits.MemoryMB * 1024 * 1024), "max"},
+		{"cpu.max", limits.CPUPercent > 0, fmt.Sprintf("%d %d", limits.CPUPercent*cpuPeriodUsec/100, cpuPeriodUsec), fmt.Sprintf("max %d", cpuPeriodUsec)},
+		{"pids.max", limits.Pids > 0, strconv.Itoa(limits.Pids), "max"},
+	}
+	for _, setting := range settings {
+		file := filepath.Join(path, setting.file)
+		if !setting.set {
+			// Clear limits left over from an earlier configuration
+			os.WriteFile(file, []byte(setting.reset), 0644)
+			continue
+		}
+		if err := os.WriteFile(file, []byte(setting.value), 0644); err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("%s: %w", setting.file, err)
+		}
+	}
+
+	return path, nil
+}
+
+func enableControllers(dir string, controllers []string) {
+	for _, c := range controllers {
+		os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+c), 0644)
+	}
+}
+
+func readCgroupUint(dir, file string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
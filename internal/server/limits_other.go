@@ -0,0 +1,33 @@
+//go:build !linux
+
+package server
+
+import "os/exec"
+
+// limiter is a no-op outside of Linux, where neither cgroups nor prlimit
+// are available
+type limiter struct{}
+
+func newLimiter(s *Server, logger *Logger) *limiter {
+	if !s.Limits.empty() {
+		logger.System("resource limits are only supported on Linux and are not enforced")
+	}
+	return nil
+}
+
+func adoptLimiter(s *Server) *limiter { return nil }
+
+func (l *limiter) prepare(cmd *exec.Cmd) error { return nil }
+
+func (l *limiter) canFallback() bool { return false }
+
+func (l *limiter) fallback() {}
+
+func (l *limiter) started(pid int) {}
+
+func (l *limiter) usage() *ResourceUsage { return nil }
+
+func (l *limiter) cleanup() {}
+
+// RunLimitWrapper does nothing outside of Linux
+func RunLimitWrapper() {}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"phpservermanager/internal/logfile"
+)
+
+const (
+	// DefaultLogLines is the number of lines kept in memory per server
+	DefaultLogLines = 1000
+	// LogFileMaxSize is the size at which a server log file is rotated
+	LogFileMaxSize = 10 * 1024 * 1024
+	// LogFileBackups is the number of rotated log files kept per server
+	LogFileBackups = 5
+
+	maxLineLength = 64 * 1024
+)
+
+// LogLine is a single line of output captured from a server process
+type LogLine struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// Logger captures the output of a server process into a bounded ring buffer
+// and a rotating log file, and fans new lines out to live subscribers.
+type Logger struct {
+	mu          sync.Mutex
+	lines       []LogLine
+	next        int
+	full        bool
+	path        string
+	file        *logfile.Writer
+	subscribers map[chan LogLine]struct{}
+}
+
+// NewLogger creates a logger that keeps the last capacity lines in memory
+// and appends every line to the file at path.
+func NewLogger(path string, capacity int) *Logger {
+	if capacity <= 0 {
+		capacity = DefaultLogLines
+	}
+	return &Logger{
+		lines:       make([]LogLine, capacity),
+		path:        path,
+		file:        logfile.New(path, LogFileMaxSize, LogFileBackups),
+		subscribers: make(map[chan LogLine]struct{}),
+	}
+}
+
+// Stream returns a writer that records everything written to it as lines
+// tagged with the given stream name (e.g. "stdout" or "stderr").
+func (l *Logger) Stream(name string) io.Writer {
+	return &streamWriter{logger: l, stream: name}
+}
+
+// System records a message generated by the manager itself, such as a
+// process being started or stopped.
+func (l *Logger) System(format string, args ...interface{}) {
+	l.add("system", fmt.Sprintf(format, args...))
+}
+
+// Tail returns up to the last n lines, oldest first. A non-positive n
+// returns everything in the buffer.
+func (l *Logger) Tail(n int) []LogLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	result := make([]LogLine, 0, n)
+	start := l.next - n
+	for i := 0; i < n; i++ {
+		idx := (start + i + len(l.lines)) % len(l.lines)
+		result = append(result, l.lines[idx])
+	}
+	return result
+}
+
+// Subscribe returns a channel receiving every new line and a function to
+// cancel the subscription. Slow subscribers miss lines instead of blocking
+// the process output.
+func (l *Logger) Subscribe() (<-chan LogLine, func()) {
+	ch := make(chan LogLine, 256)
+
+	l.mu.Lock()
+	l.subscribers[ch] = struct{}{}
+	l.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.subscribers, ch)
+			l.mu.Unlock()
+		})
+	}
+}
+
+// Close closes the log file. The in-memory buffer stays readable.
+func (l *Logger) Close() error {
+	return l.file.Close()
+}
+
+func (l *Logger) add(stream, text string) {
+	line := LogLine{Time: time.Now(), Stream: stream, Text: text}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % len(l.lines)
+	if l.next == 0 {
+		l.full = true
+	}
+
+	fmt.Fprintf(l.file, "%s [%s] %s\n", line.Time.Format(time.RFC3339Nano), stream, text)
+
+	for ch := range l.subscribers {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// streamWriter splits raw process output into lines for a Logger
+type streamWriter struct {
+	mu      sync.Mutex
+	logger  *Logger
+	stream  string
+	partial []byte
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		w.logger.add(w.stream, string(bytes.TrimRight(data[:i], "\r")))
+		data = data[i+1:]
+	}
+	for len(data) > maxLineLength {
+		w.logger.add(w.stream, string(data[:maxLineLength]))
+		data = data[maxLineLength:]
+	}
+	w.partial = append(w.partial[:0], data...)
+
+	return len(p), nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func texts(lines []LogLine) []string {
+	var got []string
+	for _, line := range lines {
+		got = append(got, line.Text)
+	}
+	return got
+}
+
+func TestLoggerTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.log")
+	l := NewLogger(path, 3)
+	defer l.Close()
+
+	if got := l.Tail(0); len(got) != 0 {
+		t.Errorf("empty logger: Tail(0) = %q", texts(got))
+	}
+
+	l.System("1")
+	l.System("2")
+	if got := strings.Join(texts(l.Tail(0)), ","); got != "1,2" {
+		t.Errorf("before wrapping: Tail(0) = %s, want 1,2", got)
+	}
+
+	for i := 3; i <= 7; i++ {
+		l.System("%d", i)
+	}
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "5,6,7"},
+		{-1, "5,6,7"},
+		{2, "6,7"},
+		{3, "5,6,7"},
+		{10, "5,6,7"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(texts(l.Tail(tt.n)), ","); got != tt.want {
+			t.Errorf("after wrapping: Tail(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+
+	// The log file keeps every line
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 7 {
+		t.Errorf("log file has %d lines, want 7", n)
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	l := NewLogger(filepath.Join(t.TempDir(), "1.log"), 100)
+	defer l.Close()
+	ch, cancel := l.Subscribe()
+	defer cancel()
+
+	w := l.Stream("stderr")
+	long := strings.Repeat("x", maxLineLength)
+	for _, chunk := range []string{
+		"one\r\ntw",
+		"o\n",
+		"",
+		"\n",
+		long + "y",
+		"z\n",
+		"no newline",
+	} {
+		if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+
+	want := []string{"one", "two", "", long, "yz"}
+	got := l.Tail(0)
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(got), len(want))
+	}
+	for i, line := range got {
+		if line.Text != want[i] || line.Stream != "stderr" {
+			t.Errorf("line %d = %s %.20q, want stderr %.20q", i, line.Stream, line.Text, want[i])
+		}
+	}
+
+	// Subscribers receive the same lines
+	for i := range want {
+		if line := <-ch; line.Text != want[i] {
+			t.Errorf("subscriber line %d = %.20q, want %.20q", i, line.Text, want[i])
+		}
+	}
+	select {
+	case line := <-ch:
+		t.Errorf("subscriber got unexpected line %.20q", line.Text)
+	default:
+	}
+
+	cancel()
+	l.System("after cancel")
+	select {
+	case line := <-ch:
+		t.Errorf("cancelled subscriber got %q", line.Text)
+	default:
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import "time"
+
+// clockTicks is USER_HZ, the unit of CPU times in /proc/<pid>/stat. It is
+// 100 on every architecture Linux supports.
+const clockTicks = 100
+
+// ProcessMetrics is a sample of the resource usage of a server's process
+// group
+type ProcessMetrics struct {
+	Time       time.Time `json:"time"`
+	Uptime     int64     `json:"uptime"`
+	RSSBytes   uint64    `json:"rss_bytes"`
+	CPUPercent float64   `json:"cpu_percent"`
+	OpenFDs    int       `json:"open_fds"`
+	Children   int       `json:"children"`
+}
+
+// GroupUsage is the combined usage of all processes in a process group.
+// CPUTime is cumulative, so CPU percentages come from comparing two readings.
+type GroupUsage struct {
+	CPUTime   time.Duration
+	RSSBytes  uint64
+	OpenFDs   int
+	Processes int
+}
+
+// CPUPercent returns the CPU usage between an earlier reading prev, taken
+// elapsed ago, and u. 100 means one CPU was fully used.
+func (u GroupUsage) CPUPercent(prev GroupUsage, elapsed time.Duration) float64 {
+	if elapsed <= 0 || u.CPUTime < prev.CPUTime {
+		return 0
+	}
+	return float64(u.CPUTime-prev.CPUTime) / float64(elapsed) * 100
+}
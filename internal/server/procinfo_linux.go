@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processStartTime returns the start time of pid in clock ticks since boot,
+// as reported by /proc/<pid>/stat
+func processStartTime(pid int) (uint64, error) {
+	fields, err := procStatFields(pid)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// ProcessGroupUsage adds up the CPU time, resident memory and open file
+// descriptors of every process in the process group pgid
+func ProcessGroupUsage(pgid int) (GroupUsage, error) {
+	var usage GroupUsage
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return usage, err
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fields, err := procStatFields(pid)
+		if err != nil || fields[2] != strconv.Itoa(pgid) {
+			continue
+		}
+
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		rss, _ := strconv.ParseUint(fields[21], 10, 64)
+		usage.CPUTime += time.Duration(utime+stime) * time.Second / clockTicks
+		usage.RSSBytes += rss * pageSize
+		if fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid)); err == nil {
+			usage.OpenFDs += len(fds)
+		}
+		usage.Processes++
+	}
+
+	if usage.Processes == 0 {
+		return usage, fmt.Errorf("no processes in group %d", pgid)
+	}
+	return usage, nil
+}
+
+// procStatFields returns the fields of /proc/<pid>/stat following the
+// command name, so index 0 is the process state (field 3 in proc(5))
+func procStatFields(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return fields, nil
+}
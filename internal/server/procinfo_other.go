@@ -0,0 +1,16 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// processStartTime is only implemented on Linux. Without it orphaned
+// processes can't be verified and are never adopted or killed.
+func processStartTime(pid int) (uint64, error) {
+	return 0, errors.New("process start time is not available on this platform")
+}
+
+// ProcessGroupUsage is only implemented on Linux
+func ProcessGroupUsage(pgid int) (GroupUsage, error) {
+	return GroupUsage{}, errors.New("process metrics are not available on this platform")
+}
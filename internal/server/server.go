@@ -1,111 +1,417 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
+
+	"phpservermanager/internal/auth"
+)
+
+// Process states
+const (
+	StateStopped  = "stopped"
+	StateRunning  = "running"
+	StateStopping = "stopping"
+)
+
+// DefaultStopTimeout is how long Stop waits for a process to exit after
+// SIGTERM before killing it
+const DefaultStopTimeout = 10 * time.Second
+
+// Restart policies
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
 )
 
 // Server represents a PHP server configuration
 type Server struct {
-	ID              string `json:"id"`
-	Name            string `json:"name"`
-	Host            string `json:"host"`
-	Port            string `json:"port"`
-	Directory       string `json:"directory"`
-	Command         string `json:"command"`
-	Running         bool   `json:"running"`
-	ACMEEnabled     bool   `json:"acme_enabled"`
-	ACMECertEmail   string `json:"acme_cert_email"`
-	ACMEDomains     []string `json:"acme_domains"`
-	ACMEStoragePath string `json:"acme_storage_path"`
-}
-
-// Start starts a PHP server
-func Start(s *Server, processes map[string]*exec.Cmd, mu *sync.Mutex) bool {
-	var command string
-	bindHost := formatHostForBinding(s.Host)
-	listenAddr := bindHost + ":" + s.Port
-	if s.ACMEEnabled {
-		listenAddr = "https://" + listenAddr
-	}
-	if s.Command != "" {
-		command = s.Command
-		command = strings.ReplaceAll(command, "{host}", s.Host)
-		command = strings.ReplaceAll(command, "{port}", s.Port)
-		command = strings.ReplaceAll(command, "{directory}", s.Directory)
-		command = strings.ReplaceAll(command, "{bind_host}", bindHost)
-		command = strings.ReplaceAll(command, "{listen_addr}", listenAddr)
-	} else {
-		command = fmt.Sprintf("frankenphp php-server --listen %s -r %s", listenAddr, s.Directory)
-	}
-
-	os.Setenv("PATH", "/usr/local/bin:"+os.Getenv("PATH")) // Tetap untuk Linux/macOS
-
-	username := getCurrentUsername()
-	fullCommand := fmt.Sprintf("sudo -u %s /bin/bash -c '%s'", username, command)
-	cmd := exec.Command("/bin/bash", "-c", fullCommand)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	cmd.Dir, _ = os.Getwd()
-
-	err := cmd.Start()
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	Host            string            `json:"host"`
+	Port            string            `json:"port"`
+	Directory       string            `json:"directory"`
+	Command         string            `json:"command"`
+	Args            []string          `json:"args,omitempty"`
+	Shell           bool              `json:"shell"`
+	Running         bool              `json:"running"`
+	State           string            `json:"state"`
+	StopTimeout     int               `json:"stop_timeout"`
+	DesiredState    string            `json:"desired_state"`
+	Autostart       bool              `json:"autostart"`
+	StartOrder      int               `json:"start_order"`
+	StartDelay      int               `json:"start_delay"`
+	Pid             int               `json:"pid,omitempty"`
+	ProcStart       uint64            `json:"proc_start,omitempty"`
+	StartedAt       *time.Time        `json:"started_at,omitempty"`
+	ACMEEnabled     bool              `json:"acme_enabled"`
+	ACMECertEmail   string            `json:"acme_cert_email"`
+	ACMEDomains     []string          `json:"acme_domains"`
+	ACMEStoragePath string            `json:"acme_storage_path"`
+	RestartPolicy   string            `json:"restart_policy"`
+	MaxRetries      int               `json:"max_retries"`
+	RetryWindow     int               `json:"retry_window"`
+	RestartCount    int               `json:"restart_count"`
+	LastExitCode    int               `json:"last_exit_code"`
+	LastExitReason  string            `json:"last_exit_reason,omitempty"`
+	LastExitAt      *time.Time        `json:"last_exit_at,omitempty"`
+	HealthCheck     *HealthCheck      `json:"health_check,omitempty"`
+	Health          string            `json:"health,omitempty"`
+	HealthMessage   string            `json:"health_message,omitempty"`
+	LastHealthCheck *time.Time        `json:"last_health_check,omitempty"`
+	RunAsUser       string            `json:"run_as_user,omitempty"`
+	RunAsGroup      string            `json:"run_as_group,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	Secrets         map[string]string `json:"secrets,omitempty"`
+	LoadEnvFile     bool              `json:"load_env_file"`
+	Limits          *Limits           `json:"limits,omitempty"`
+	Owner           string            `json:"owner,omitempty"`
+	ACL             []auth.ACLEntry   `json:"acl,omitempty"`
+	// Metrics and Permissions, the caller's permissions on the server, are
+	// filled in for API responses only
+	Metrics     *ProcessMetrics `json:"metrics,omitempty"`
+	Permissions []string        `json:"permissions,omitempty"`
+}
+
+// Status is a snapshot of a server's runtime state
+type Status struct {
+	Running         bool             `json:"running"`
+	State           string           `json:"state"`
+	Pid             int              `json:"pid,omitempty"`
+	StartedAt       *time.Time       `json:"started_at,omitempty"`
+	RestartCount    int              `json:"restart_count"`
+	Health          string           `json:"health,omitempty"`
+	HealthMessage   string           `json:"health_message,omitempty"`
+	LastHealthCheck *time.Time       `json:"last_health_check,omitempty"`
+	Limits          *Limits          `json:"limits,omitempty"`
+	Usage           *ResourceUsage   `json:"usage,omitempty"`
+	Metrics         *ProcessMetrics  `json:"metrics,omitempty"`
+	History         []ProcessMetrics `json:"history,omitempty"`
+}
+
+// Status returns the runtime state of s
+func (s *Server) Status() Status {
+	return Status{
+		Running:         s.Running,
+		State:           s.State,
+		Pid:             s.Pid,
+		StartedAt:       s.StartedAt,
+		RestartCount:    s.RestartCount,
+		Health:          s.Health,
+		HealthMessage:   s.HealthMessage,
+		LastHealthCheck: s.LastHealthCheck,
+		Limits:          s.Limits,
+	}
+}
+
+// Allows reports whether u has perm on s, through its role, ownership of s
+// or the ACL of s
+func (s *Server) Allows(u *auth.User, perm string) bool {
+	return u.CanOn(perm, s.Owner, s.ACL)
+}
+
+// Clone returns a deep copy of s
+func (s *Server) Clone() *Server {
+	c := *s
+	if s.ACMEDomains != nil {
+		c.ACMEDomains = append([]string(nil), s.ACMEDomains...)
+	}
+	if s.HealthCheck != nil {
+		hc := *s.HealthCheck
+		c.HealthCheck = &hc
+	}
+	if s.Args != nil {
+		c.Args = append([]string(nil), s.Args...)
+	}
+	if s.Limits != nil {
+		l := *s.Limits
+		c.Limits = &l
+	}
+	if s.ACL != nil {
+		c.ACL = make([]auth.ACLEntry, len(s.ACL))
+		for i, e := range s.ACL {
+			e.Permissions = append([]string(nil), e.Permissions...)
+			c.ACL[i] = e
+		}
+	}
+	c.Env = copyMap(s.Env)
+	c.Secrets = copyMap(s.Secrets)
+	return &c
+}
+
+// Process is a server process tracked by the manager. Pid is also the
+// process group ID. Cmd is nil for processes adopted from a previous
+// manager instance.
+type Process struct {
+	Cmd  *exec.Cmd
+	Pid  int
+	Done chan struct{}
+
+	stopRequested bool
+	limiter       *limiter
+	output        []*captureTail
+}
+
+// ExitStatus describes how a server process terminated
+type ExitStatus struct {
+	Code      int
+	Reason    string
+	Requested bool
+}
+
+// ValidRestartPolicy reports whether policy is a known restart policy
+func ValidRestartPolicy(policy string) bool {
+	switch policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return true
+	}
+	return false
+}
+
+// ShouldRestart reports whether a process that ended with status should be
+// restarted under the server's restart policy
+func (s *Server) ShouldRestart(status ExitStatus) bool {
+	if status.Requested {
+		return false
+	}
+	switch s.RestartPolicy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return status.Code != 0
+	}
+	return false
+}
+
+// Start starts a PHP server, sending its output to logger. onExit, if not
+// nil, is called once the process has terminated.
+func Start(s *Server, processes map[string]*Process, mu *sync.Mutex, logger *Logger, onExit func(ExitStatus)) bool {
+	argv, err := s.BuildArgs()
+	if err != nil {
+		fmt.Printf("Error building command: %v\n", err)
+		logger.System("invalid command: %v", err)
+		return false
+	}
+
+	cred, u, err := s.Credential()
+	if err != nil {
+		fmt.Printf("Error resolving user: %v\n", err)
+		logger.System("failed to resolve user: %v", err)
+		return false
+	}
+
+	env, err := s.Environment(u)
+	if err != nil {
+		fmt.Printf("Error preparing environment: %v\n", err)
+		logger.System("failed to prepare environment: %v", err)
+		return false
+	}
+
+	path, err := lookPath(argv[0], env)
 	if err != nil {
 		fmt.Printf("Error starting server: %v\n", err)
+		logger.System("failed to start: %v", err)
 		return false
 	}
 
+	stdout, err := openCapture(logger, "stdout")
+	if err != nil {
+		fmt.Printf("Error starting server: %v\n", err)
+		logger.System("failed to open output file: %v", err)
+		return false
+	}
+	defer stdout.Close()
+	stderr, err := openCapture(logger, "stderr")
+	if err != nil {
+		fmt.Printf("Error starting server: %v\n", err)
+		logger.System("failed to open output file: %v", err)
+		return false
+	}
+	defer stderr.Close()
+
+	newCmd := func() *exec.Cmd {
+		cmd := exec.Command(path, argv[1:]...)
+		cmd.Args[0] = argv[0]
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+		cmd.Env = env
+
+		cmd.Dir, _ = os.Getwd()
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		return cmd
+	}
+
+	limits := newLimiter(s, logger)
+	cmd := newCmd()
+	err = limits.prepare(cmd)
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil && limits.canFallback() {
+		logger.System("failed to start in cgroup (%v), falling back to setrlimit", err)
+		limits.fallback()
+		cmd = newCmd()
+		err = limits.prepare(cmd)
+		if err == nil {
+			err = cmd.Start()
+		}
+	}
+	if err != nil {
+		limits.cleanup()
+		fmt.Printf("Error starting server: %v\n", err)
+		logger.System("failed to start: %v", err)
+		return false
+	}
+	limits.started(cmd.Process.Pid)
+	logger.System("started %s (pid %d)", FormatArgs(argv), cmd.Process.Pid)
+
+	proc := &Process{Cmd: cmd, Pid: cmd.Process.Pid, Done: make(chan struct{}), limiter: limits, output: tailOutput(logger)}
+	procStart, _ := processStartTime(proc.Pid)
+	now := time.Now()
+
 	mu.Lock()
-	processes[s.ID] = cmd
+	processes[s.ID] = proc
 	s.Running = true
+	s.State = StateRunning
+	s.Pid = proc.Pid
+	s.ProcStart = procStart
+	s.StartedAt = &now
 	mu.Unlock()
 
 	go func() {
-		cmd.Wait()
-		mu.Lock()
-		delete(processes, s.ID)
-		s.Running = false
-		mu.Unlock()
+		code, reason := exitStatus(cmd.Wait())
+		processExited(s, processes, mu, proc, logger, onExit, code, reason)
 	}()
 
 	return true
 }
 
-// Stop stops a running PHP server
-func Stop(s *Server, processes map[string]*exec.Cmd, mu *sync.Mutex) bool {
+// processExited records the termination of proc and notifies onExit
+func processExited(s *Server, processes map[string]*Process, mu *sync.Mutex, proc *Process, logger *Logger, onExit func(ExitStatus), code int, reason string) {
+	for _, t := range proc.output {
+		t.Close()
+	}
+	logger.System("%s", reason)
+
+	now := time.Now()
 	mu.Lock()
-	cmd, exists := processes[s.ID]
+	if processes[s.ID] == proc {
+		delete(processes, s.ID)
+		s.Running = false
+		s.State = StateStopped
+		s.Pid = 0
+		s.ProcStart = 0
+	}
+	s.LastExitCode = code
+	s.LastExitReason = reason
+	s.LastExitAt = &now
+	status := ExitStatus{Code: code, Reason: reason, Requested: proc.stopRequested}
+	mu.Unlock()
+
+	proc.limiter.cleanup()
+	close(proc.Done)
+	if onExit != nil {
+		onExit(status)
+	}
+}
+
+// Stop stops a running PHP server. The process group is sent SIGTERM and
+// given the server's stop timeout to exit before it is killed with SIGKILL.
+func Stop(s *Server, processes map[string]*Process, mu *sync.Mutex, logger *Logger) bool {
+	mu.Lock()
+	proc, exists := processes[s.ID]
 	if !exists {
 		s.Running = false
+		s.State = StateStopped
 		mu.Unlock()
 		return true
 	}
+	proc.stopRequested = true
+	s.State = StateStopping
+	timeout := DefaultStopTimeout
+	if s.StopTimeout > 0 {
+		timeout = time.Duration(s.StopTimeout) * time.Second
+	}
 	mu.Unlock()
 
-	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+	pgid := proc.Pid
+	logger.System("stopping (SIGTERM, timeout %s)", timeout)
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
 		fmt.Printf("Error stopping server: %v\n", err)
+		mu.Lock()
+		proc.stopRequested = false
+		s.State = StateRunning
+		mu.Unlock()
 		return false
 	}
 
-	mu.Lock()
-	delete(processes, s.ID)
-	s.Running = false
-	mu.Unlock()
+	select {
+	case <-proc.Done:
+		return true
+	case <-time.After(timeout):
+	}
+
+	logger.System("did not exit within %s, sending SIGKILL", timeout)
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		fmt.Printf("Error killing server: %v\n", err)
+		return false
+	}
+
+	select {
+	case <-proc.Done:
+	case <-time.After(5 * time.Second):
+		fmt.Printf("Server %s did not exit after SIGKILL\n", s.ID)
+		return false
+	}
 
 	return true
 }
 
-func getCurrentUsername() string {
-	user, err := os.UserHomeDir()
+// exitStatus converts the result of cmd.Wait into an exit code and a human
+// readable reason. Processes killed by a signal report code -1.
+func exitStatus(err error) (int, string) {
+	if err == nil {
+		return 0, "exited with code 0"
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return -1, fmt.Sprintf("killed by signal %s", ws.Signal())
+		}
+		return exitErr.ExitCode(), fmt.Sprintf("exited with code %d", exitErr.ExitCode())
+	}
+
+	return -1, err.Error()
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// PortAvailable checks that host:port can currently be bound, by briefly
+// listening on it
+func PortAvailable(host, port string) error {
+	l, err := net.Listen("tcp", formatHostForBinding(host)+":"+port)
 	if err != nil {
-		return "root" // Fallback for non-Windows
+		return err
 	}
-	return filepath.Base(user)
+	return l.Close()
 }
 
 func formatHostForBinding(host string) string {
@@ -115,4 +421,4 @@ func formatHostForBinding(host string) string {
 		}
 	}
 	return host
-}
\ No newline at end of file
+}
@@ -0,0 +1,41 @@
+package server
+
+import "testing"
+
+func TestShouldRestart(t *testing.T) {
+	crashed := ExitStatus{Code: 1}
+	exited := ExitStatus{Code: 0}
+	stopped := ExitStatus{Code: -1, Requested: true}
+
+	tests := []struct {
+		policy string
+		status ExitStatus
+		want   bool
+	}{
+		{"", crashed, false},
+		{RestartNever, crashed, false},
+		{RestartOnFailure, crashed, true},
+		{RestartOnFailure, exited, false},
+		{RestartOnFailure, stopped, false},
+		{RestartAlways, crashed, true},
+		{RestartAlways, exited, true},
+		{RestartAlways, stopped, false},
+	}
+	for _, tt := range tests {
+		s := &Server{RestartPolicy: tt.policy}
+		if got := s.ShouldRestart(tt.status); got != tt.want {
+			t.Errorf("policy %q, status %+v: ShouldRestart = %v, want %v", tt.policy, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestValidRestartPolicy(t *testing.T) {
+	for _, policy := range []string{"", RestartNever, RestartOnFailure, RestartAlways} {
+		if !ValidRestartPolicy(policy) {
+			t.Errorf("ValidRestartPolicy(%q) = false", policy)
+		}
+	}
+	if ValidRestartPolicy("sometimes") {
+		t.Error(`ValidRestartPolicy("sometimes") = true`)
+	}
+}
@@ -0,0 +1,248 @@
+// Package tlsconfig sets up HTTPS for the manager's own web server, with a
+// static certificate, a generated self-signed one, or certificates managed
+// through ACME.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+
+	"phpservermanager/internal/config"
+)
+
+// TLS modes
+const (
+	ModeStatic     = "static"
+	ModeSelfSigned = "self-signed"
+	ModeACME       = "acme"
+)
+
+const (
+	selfSignedValidity = 365 * 24 * time.Hour
+	// selfSignedRenewal is how long before expiry the self-signed
+	// certificate is generated again
+	selfSignedRenewal = 30 * 24 * time.Hour
+)
+
+// Build returns the TLS configuration for the manager's listener, or nil
+// when TLS is off. The returned wrapper is for handlers of the plain HTTP
+// listener, so that it can answer ACME HTTP challenges. Generated
+// certificates are stored in dir.
+func Build(ctx context.Context, cfg config.TLSConfig, host, dir string) (*tls.Config, func(http.Handler) http.Handler, error) {
+	passthrough := func(h http.Handler) http.Handler { return h }
+
+	switch cfg.Mode {
+	case "":
+		return nil, passthrough, nil
+
+	case ModeStatic:
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, nil, errors.New("tls mode static needs cert_file and key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading certificate: %w", err)
+		}
+		return newConfig(cert), passthrough, nil
+
+	case ModeSelfSigned:
+		ss := &selfSigned{
+			certFile: filepath.Join(dir, "tls", "manager.crt"),
+			keyFile:  filepath.Join(dir, "tls", "manager.key"),
+			host:     host,
+		}
+		if _, err := ss.certificate(); err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return ss.certificate() },
+			MinVersion:     tls.VersionTLS12,
+		}, passthrough, nil
+
+	case ModeACME:
+		if len(cfg.ACME.Domains) == 0 {
+			return nil, nil, errors.New("tls mode acme needs at least one domain")
+		}
+		storagePath := cfg.ACME.StoragePath
+		if storagePath == "" {
+			storagePath = filepath.Join(dir, "certs")
+		}
+
+		magic := certmagic.NewDefault()
+		magic.Storage = &certmagic.FileStorage{Path: storagePath}
+		issuer := certmagic.NewACMEIssuer(magic, certmagic.ACMEIssuer{
+			CA:     cfg.ACME.CA,
+			Email:  cfg.ACME.Email,
+			Agreed: true,
+		})
+		magic.Issuers = []certmagic.Issuer{issuer}
+		if err := magic.ManageAsync(ctx, cfg.ACME.Domains); err != nil {
+			return nil, nil, fmt.Errorf("managing certificates: %w", err)
+		}
+
+		tlsConfig := magic.TLSConfig()
+		tlsConfig.NextProtos = append([]string{"h2", "http/1.1"}, tlsConfig.NextProtos...)
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, issuer.HTTPChallengeHandler, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown tls mode %q", cfg.Mode)
+}
+
+func newConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// selfSigned serves the generated certificate, replacing it when it is
+// about to expire so that a long running manager never serves an expired
+// one
+type selfSigned struct {
+	certFile, keyFile, host string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	notAfter time.Time
+	retry    time.Time
+}
+
+// certificate returns the current certificate, loading or generating it
+// when there is none yet or it expires soon
+func (s *selfSigned) certificate() (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cert != nil && (time.Until(s.notAfter) >= selfSignedRenewal || time.Now().Before(s.retry)) {
+		return s.cert, nil
+	}
+
+	cert, err := loadSelfSigned(s.certFile, s.keyFile)
+	if err != nil {
+		if cert, err = generateSelfSigned(s.certFile, s.keyFile, s.host); err != nil {
+			if s.cert != nil {
+				// Keep serving the old one until it can be replaced
+				fmt.Printf("Error renewing self-signed certificate: %v\n", err)
+				s.retry = time.Now().Add(time.Hour)
+				return s.cert, nil
+			}
+			return nil, fmt.Errorf("generating self-signed certificate: %w", err)
+		}
+		fmt.Printf("Generated a self-signed certificate in %s\n", s.certFile)
+	}
+	s.cert = &cert
+	s.notAfter = cert.Leaf.NotAfter
+	return s.cert, nil
+}
+
+// loadSelfSigned loads the generated certificate unless it is about to
+// expire
+func loadSelfSigned(certFile, keyFile string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return cert, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return cert, err
+	}
+	if time.Until(leaf.NotAfter) < selfSignedRenewal {
+		return cert, errors.New("certificate expires soon")
+	}
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// generateSelfSigned creates a certificate for host, localhost and the
+// machine's hostname, and stores it with its key
+func generateSelfSigned(certFile, keyFile, host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "PHP Server Manager"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, hostname)
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+	} else if ip == nil && host != "" && host != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return cert, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(der)
+	return cert, err
+}
+
+// RedirectHandler redirects requests to the same host and path over HTTPS
+// on httpsPort
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}